@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"flag"
@@ -11,6 +12,7 @@ import (
 	"os"
 	"os/signal"
 	"regexp"
+	"strings"
 	"syscall"
 	"time"
 
@@ -18,7 +20,7 @@ import (
 
 	"github.com/Jeskay/musthave_metrics/config"
 	"github.com/Jeskay/musthave_metrics/internal/agent"
-	"github.com/Jeskay/musthave_metrics/internal/util"
+	"github.com/Jeskay/musthave_metrics/pkg/retry"
 )
 
 var conf *config.AgentConfig
@@ -28,9 +30,6 @@ var buildDate string
 var buildCommit string
 
 func main() {
-	sig := make(chan os.Signal, 1)
-	var endMonitor, endSender chan<- struct{}
-
 	if buildVersion == "" {
 		buildVersion = "N/A"
 	}
@@ -43,24 +42,41 @@ func main() {
 
 	fmt.Printf("Build version: %s \nBuild date: %s \nBuild commit: %s \n", buildVersion, buildDate, buildCommit)
 
-	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 	client := &http.Client{
 		Timeout: 6 * time.Second,
 	}
+	tlsConf, err := conf.LoadTLSConfig()
+	if err != nil {
+		log.Fatalln("failed to load TLS certificates", err)
+	}
+	if tlsConf != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConf
+		client.Transport = transport
+	}
 	logger := slog.NewTextHandler(os.Stdout, nil)
 	svc := agent.NewAgentService(client, conf, logger)
-	err := util.TryRun(func() error {
+	if conf.RelabelDryRun {
+		err = svc.RelabelDryRun(ctx, os.Stdout)
+		stop()
+		if err != nil {
+			log.Fatalln("relabel dry run failed", err)
+		}
+		return
+	}
+	err = retry.Do(ctx, retry.Default.WithRetryable(retry.IsConnectionRefused), func(ctx context.Context) error {
 		return svc.CheckAPIAvailability()
-	}, util.IsConnectionRefused)
+	})
 
 	if err != nil {
 		slog.Error(err.Error())
 	}
-	endMonitor = svc.StartMonitoring(conf.GetReportInterval())
-	endSender = svc.StartSending(conf.GetPollInterval())
-	<-sig
-	endMonitor <- struct{}{}
-	endSender <- struct{}{}
+	err = svc.Run(ctx)
+	stop()
+	if err != nil {
+		log.Fatalln("agent stopped", err)
+	}
 }
 
 func init() {
@@ -93,7 +109,36 @@ func loadParams() *config.AgentConfig {
 	flag.StringVar(&paramCfg.HashKey, "k", "", "secret hash key")
 	flag.StringVar(&paramCfg.PublicKey, "crypto-key", "", "path to cryptographic key file")
 	flag.StringVar(&paramCfg.Config, "config", "", "path to configuration file")
+	flag.StringVar(&paramCfg.TLSCA, "tls-ca", "", "path to CA bundle for server certificate verification")
+	flag.StringVar(&paramCfg.TLSCert, "tls-cert", "", "path to client TLS certificate file")
+	flag.StringVar(&paramCfg.TLSKey, "tls-key", "", "path to client TLS private key file")
 	flag.IntVar(&paramCfg.PollInterval, "p", 2, "poll frequency in seconds")
+	flag.StringVar(&paramCfg.PushAddress, "push", "", "local address to accept custom metrics on, host:port or unix:/path")
+	flag.StringVar(&paramCfg.StatusAddress, "status", "", "local address of the health and status endpoints, host:port or unix:/path")
+	flag.StringVar(&paramCfg.LogTailState, "log-state", "", "file to keep read offsets of the tailed log files in")
+	flag.StringVar(&paramCfg.CgroupRoot, "cgroup-root", "", "mount point of the cgroup v2 hierarchy")
+	flag.StringVar(&paramCfg.OutboxDir, "outbox", "", "directory to queue metrics undelivered due to server outage in")
+	flag.IntVar(&paramCfg.OutboxMaxSize, "outbox-size", 0, "maximum size of the outbox in megabytes")
+	flag.IntVar(&paramCfg.OutboxMaxAge, "outbox-age", 0, "maximum age of the queued metrics in seconds")
+	flag.IntVar(&paramCfg.BreakerThreshold, "breaker-threshold", 0, "number of consecutive delivery failures opening the circuit breaker")
+	flag.IntVar(&paramCfg.BreakerCooldown, "breaker-cooldown", 0, "seconds before the server is probed after the circuit breaker opens")
+	flag.IntVar(&paramCfg.BatchMaxCount, "batch-count", 0, "maximum number of metrics in a batch")
+	flag.IntVar(&paramCfg.BatchMaxBytes, "batch-bytes", 0, "maximum size of compressed batch in bytes")
+	flag.IntVar(&paramCfg.ShutdownTimeout, "shutdown-timeout", 0, "seconds given to the final delivery of metrics on shutdown")
+	flag.BoolVar(&paramCfg.RelabelDryRun, "relabel-dry-run", false, "print effects of the relabel rules on the collected metrics and exit")
+	flag.IntVar(&paramCfg.AggregationMaxSeries, "aggregation-series", 0, "maximum number of gauges aggregated within the report interval")
+	flag.IntVar(&paramCfg.NegotiateInterval, "negotiate-interval", 0, "seconds between negotiations of the transport with the server")
+	flag.StringVar(&paramCfg.AgentID, "id", "", "identity of the agent used to resolve its settings on the server")
+	flag.StringVar(&paramCfg.AgentGroup, "group", "", "group of the agent used to resolve its settings on the server")
+	flag.IntVar(&paramCfg.RemoteConfigInterval, "remote-config", 0, "seconds between polls of the agent settings served by the server")
+	flag.Func("lock", "comma separated list of settings not changed by the server", func(s string) error {
+		paramCfg.LockedSettings = strings.Split(s, ",")
+		return nil
+	})
+	flag.Func("collectors", "comma separated list of enabled collectors", func(s string) error {
+		paramCfg.Collectors = strings.Split(s, ",")
+		return nil
+	})
 	flag.Func("a", "server address", func(s string) error {
 		if len(s) == 0 {
 			return nil
@@ -62,19 +62,38 @@ func main() {
 
 	service := initHelper(conf, zapL)
 
-	r := routes.Init(conf, service, t)
+	r, err := routes.Init(conf, service, t)
+	if err != nil {
+		zapL.Fatal("failed to init routes", zap.Error(err))
+	}
+	tlsConf, err := conf.LoadTLSConfig()
+	if err != nil {
+		zapL.Fatal("failed to load TLS certificates", zap.Error(err))
+	}
+	srv := &http.Server{
+		Addr:      conf.Address,
+		Handler:   r,
+		TLSConfig: tlsConf,
+	}
 	go func() {
-		if err := r.Run(conf.Address); err != nil && err != http.ErrServerClosed {
+		var err error
+		if tlsConf != nil {
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			zapL.Fatal("server stopped", zap.Error(err))
 		}
 	}()
 	service.StartSaving()
 
-	shutdownHelper(context.Background(), service, zapL)
+	shutdownHelper(context.Background(), srv, service, zapL)
 }
 
 func initHelper(conf *config.ServerConfig, logger *zap.Logger) *metric.MetricService {
 	var storage internal.Repositories
+	var idempotency internal.IdempotencyStore
 
 	fs, err := db.NewFileStorage(conf.StoragePath)
 	if err != nil {
@@ -83,6 +102,7 @@ func initHelper(conf *config.ServerConfig, logger *zap.Logger) *metric.MetricSer
 
 	if conf.DBConnection == "" {
 		storage = db.NewMemStorage()
+		idempotency = db.NewMemIdempotencyStore(conf.GetIdempotencyTTL())
 	} else {
 		database, err := sql.Open("pgx", conf.DBConnection)
 		if err != nil {
@@ -91,11 +111,14 @@ func initHelper(conf *config.ServerConfig, logger *zap.Logger) *metric.MetricSer
 		if storage, err = db.NewPostgresStorage(database, zapslog.NewHandler(logger.Core(), nil)); err != nil {
 			logger.Fatal("failed to init database", zap.Error(err))
 		}
+		if idempotency, err = db.NewPostgresIdempotencyStore(database, conf.GetIdempotencyTTL(), zapslog.NewHandler(logger.Core(), nil)); err != nil {
+			logger.Fatal("failed to init database", zap.Error(err))
+		}
 	}
-	return metric.NewMetricService(*conf, zapslog.NewHandler(logger.Core(), nil), fs, storage)
+	return metric.NewMetricService(*conf, zapslog.NewHandler(logger.Core(), nil), fs, storage, idempotency)
 }
 
-func shutdownHelper(ctx context.Context, service *metric.MetricService, logger *zap.Logger) {
+func shutdownHelper(ctx context.Context, srv *http.Server, service *metric.MetricService, logger *zap.Logger) {
 	sig := make(chan os.Signal, 1)
 
 	signal.Notify(sig, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGINT)
@@ -103,6 +126,9 @@ func shutdownHelper(ctx context.Context, service *metric.MetricService, logger *
 	logger.Info("initiating server shutdown...")
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		logger.Error("failed to shutdown server", zap.Error(err))
+	}
 	service.Close()
 	<-ctx.Done()
 	logger.Info("server shutting down")
@@ -150,10 +176,21 @@ func loadParams() *config.ServerConfig {
 	var paramCfg = config.NewServerConfig()
 
 	flag.IntVar(&paramCfg.SaveInterval, "i", paramCfg.SaveInterval, "save to storage interval")
+	flag.IntVar(&paramCfg.IdempotencyTTL, "idempotency-ttl", paramCfg.IdempotencyTTL, "seconds to remember applied idempotency keys")
+	flag.IntVar(&paramCfg.MaxBatchSize, "max-batch", paramCfg.MaxBatchSize, "maximum number of metrics in a single batch, 0 disables the limit")
+	flag.StringVar(&paramCfg.AgentConfigFile, "agent-config", "", "path to JSON document with settings served to the agents")
 	flag.StringVar(&paramCfg.DBConnection, "d", "", "database connection string")
 	flag.StringVar(&paramCfg.HashKey, "k", "", "secret hash key")
 	flag.StringVar(&paramCfg.TLSPrivate, "crypto-key", "", "path to cryptographic key file")
 	flag.StringVar(&paramCfg.Config, "config", "", "path to configuration file")
+	flag.StringVar(&paramCfg.TrustedSubnet, "t", "", "trusted subnets in CIDR notation, comma separated")
+	flag.StringVar(&paramCfg.TrustedProxies, "trusted-proxies", "", "reverse proxies trusted to set X-Real-IP header in CIDR notation, comma separated")
+	flag.StringVar(&paramCfg.TLSCert, "tls-cert", "", "path to TLS certificate file")
+	flag.StringVar(&paramCfg.TLSKey, "tls-key", "", "path to TLS private key file")
+	flag.StringVar(&paramCfg.TLSClientCA, "tls-client-ca", "", "path to CA bundle for client certificate verification")
+	flag.Float64Var(&paramCfg.ClientRateLimit, "client-rate", 0, "requests per second allowed for a single client")
+	flag.IntVar(&paramCfg.ClientBurst, "client-burst", 0, "maximum burst of requests for a single client")
+	flag.IntVar(&paramCfg.MaxInFlight, "max-in-flight", 0, "maximum amount of concurrently processed requests")
 	flag.Func("f", "storage file location", func(s string) error {
 		if len(s) == 0 {
 			return nil
@@ -2,11 +2,17 @@ package config
 
 import (
 	"crypto/rsa"
+	"crypto/tls"
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
+	"net"
 	"os"
+	"slices"
+	"strings"
 	"time"
+
+	"github.com/Jeskay/musthave_metrics/internal/util"
 )
 
 type ServerConfig struct {
@@ -17,7 +23,82 @@ type ServerConfig struct {
 	DBConnection string `env:"DATABASE_DSN" json:"database_dsn"`
 	Restore      bool   `env:"RESTORE" json:"restore"`
 	HashKey      string `env:"KEY" json:"key"`
-	Config       string `env:"CONFIG"`
+	// Comma separated CIDR lists of networks allowed to access the server.
+	// Route specific lists override TrustedSubnet for their routes.
+	TrustedSubnet string `env:"TRUSTED_SUBNET" json:"trusted_subnet"`
+	WriteSubnet   string `env:"WRITE_SUBNET" json:"write_subnet"`
+	ReadSubnet    string `env:"READ_SUBNET" json:"read_subnet"`
+	AdminSubnet   string `env:"ADMIN_SUBNET" json:"admin_subnet"`
+	// Comma separated CIDR list of reverse proxies trusted to pass client address in X-Real-IP header.
+	TrustedProxies string `env:"TRUSTED_PROXIES" json:"trusted_proxies"`
+	TLSCert        string `env:"TLS_CERT" json:"tls_cert"`
+	TLSKey         string `env:"TLS_KEY" json:"tls_key"`
+	TLSClientCA    string `env:"TLS_CLIENT_CA" json:"tls_client_ca"`
+	// Requests per second allowed for a single client, zero disables the limit.
+	ClientRateLimit float64 `env:"CLIENT_RATE_LIMIT" json:"client_rate_limit"`
+	ClientBurst     int     `env:"CLIENT_BURST" json:"client_burst"`
+	// Maximum amount of concurrently processed requests, zero disables the limit.
+	MaxInFlight int `env:"MAX_IN_FLIGHT" json:"max_in_flight"`
+	// Seconds to remember idempotency keys of applied requests, one day by default.
+	// It must not be shorter than the maximum age of metrics queued in the outbox of the agents,
+	// otherwise queued batches can be applied twice when they are replayed.
+	IdempotencyTTL int `env:"IDEMPOTENCY_TTL" json:"idempotency_ttl"`
+	// Maximum number of metrics accepted in a single batch, zero disables the limit.
+	MaxBatchSize int `env:"MAX_BATCH_SIZE" json:"max_batch_size"`
+	// JSON document with settings served to the agents.
+	AgentConfigFile string `env:"AGENT_CONFIG_FILE" json:"agent_config_file"`
+	Config          string `env:"CONFIG"`
+}
+
+// Subnets returns parsed CIDR list, falling back to TrustedSubnet when the list is empty.
+// Nil result means that access is not restricted.
+func (cfg *ServerConfig) Subnets(list string) ([]*net.IPNet, error) {
+	if list == "" {
+		list = cfg.TrustedSubnet
+	}
+	return ParseSubnets(list)
+}
+
+// Proxies returns parsed CIDR list of the trusted reverse proxies.
+func (cfg *ServerConfig) Proxies() ([]*net.IPNet, error) {
+	return ParseSubnets(cfg.TrustedProxies)
+}
+
+// LoadTLSConfig returns configuration for serving HTTPS or nil if certificate is not specified.
+// When client CA bundle is set, clients are required to present certificate signed by it.
+// Certificate files are reloaded from disk on modification.
+func (cfg *ServerConfig) LoadTLSConfig() (*tls.Config, error) {
+	if cfg.TLSCert == "" || cfg.TLSKey == "" {
+		return nil, nil
+	}
+	certs, err := util.NewCertificateReloader(cfg.TLSCert, cfg.TLSKey)
+	if err != nil {
+		return nil, err
+	}
+	tlsConf := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certs.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+	if cfg.TLSClientCA == "" {
+		return tlsConf, nil
+	}
+	clientCA, err := util.NewCertPoolReloader(cfg.TLSClientCA)
+	if err != nil {
+		return nil, err
+	}
+	base := tlsConf.Clone()
+	tlsConf.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		pool, err := clientCA.Pool()
+		if err != nil {
+			return nil, err
+		}
+		c := base.Clone()
+		c.ClientAuth = tls.RequireAndVerifyClientCert
+		c.ClientCAs = pool
+		return c, nil
+	}
+	return tlsConf, nil
 }
 
 func (cfg *ServerConfig) LoadPrivateKey() (*rsa.PrivateKey, error) {
@@ -52,11 +133,74 @@ func (cfg *ServerConfig) Merge(cfgMerge *ServerConfig) {
 	if cfg.HashKey == "" {
 		cfg.HashKey = cfgMerge.HashKey
 	}
+	if cfg.TrustedSubnet == "" {
+		cfg.TrustedSubnet = cfgMerge.TrustedSubnet
+	}
+	if cfg.WriteSubnet == "" {
+		cfg.WriteSubnet = cfgMerge.WriteSubnet
+	}
+	if cfg.ReadSubnet == "" {
+		cfg.ReadSubnet = cfgMerge.ReadSubnet
+	}
+	if cfg.AdminSubnet == "" {
+		cfg.AdminSubnet = cfgMerge.AdminSubnet
+	}
+	if cfg.TrustedProxies == "" {
+		cfg.TrustedProxies = cfgMerge.TrustedProxies
+	}
+	if cfg.TLSCert == "" {
+		cfg.TLSCert = cfgMerge.TLSCert
+	}
+	if cfg.TLSKey == "" {
+		cfg.TLSKey = cfgMerge.TLSKey
+	}
+	if cfg.TLSClientCA == "" {
+		cfg.TLSClientCA = cfgMerge.TLSClientCA
+	}
+	if cfg.ClientRateLimit == 0 {
+		cfg.ClientRateLimit = cfgMerge.ClientRateLimit
+	}
+	if cfg.ClientBurst == 0 {
+		cfg.ClientBurst = cfgMerge.ClientBurst
+	}
+	if cfg.MaxInFlight == 0 {
+		cfg.MaxInFlight = cfgMerge.MaxInFlight
+	}
+	if cfg.IdempotencyTTL == 86400 {
+		cfg.IdempotencyTTL = cfgMerge.IdempotencyTTL
+	}
+	if cfg.MaxBatchSize == 1000 {
+		cfg.MaxBatchSize = cfgMerge.MaxBatchSize
+	}
+	if cfg.AgentConfigFile == "" {
+		cfg.AgentConfigFile = cfgMerge.AgentConfigFile
+	}
 	if cfg.Config == "" {
 		cfg.Config = cfgMerge.Config
 	}
 }
 
+func (cfg *ServerConfig) GetIdempotencyTTL() time.Duration {
+	return time.Second * time.Duration(cfg.IdempotencyTTL)
+}
+
+// ParseSubnets parses comma separated list of CIDR networks.
+func ParseSubnets(list string) ([]*net.IPNet, error) {
+	var subnets []*net.IPNet
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		_, subnet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		subnets = append(subnets, subnet)
+	}
+	return subnets, nil
+}
+
 type AgentConfig struct {
 	PublicKey      string `env:"CRYPTO_KEY" json:"public_key"`
 	Address        string `env:"ADDRESS" json:"address"`
@@ -64,7 +208,150 @@ type AgentConfig struct {
 	PollInterval   int    `env:"POLL_INTERVAL" json:"poll_interval"`
 	RateLimit      int    `env:"RATE_LIMIT" json:"rate_limit"`
 	HashKey        string `env:"KEY" json:"key"`
-	Config         string `env:"CONFIG"`
+	TLSCA          string `env:"TLS_CA" json:"tls_ca"`
+	TLSCert        string `env:"TLS_CERT" json:"tls_cert"`
+	TLSKey         string `env:"TLS_KEY" json:"tls_key"`
+	// Metric servers to deliver metrics to, can be set only in JSON configuration.
+	// Address, hash key and public key above are used when the list is empty.
+	Destinations []Destination `json:"destinations"`
+	// Names of the enabled metric collectors, default set is used when empty.
+	// Go runtime statistics are reported either by legacy "memstats" or by "runtime" collector.
+	Collectors []string `env:"COLLECTORS" envSeparator:"," json:"collectors"`
+	// Regular expressions of disk devices or mount points and network interfaces to report.
+	DiskInclude []string `env:"DISK_INCLUDE" envSeparator:"," json:"disk_include"`
+	DiskExclude []string `env:"DISK_EXCLUDE" envSeparator:"," json:"disk_exclude"`
+	NetInclude  []string `env:"NET_INCLUDE" envSeparator:"," json:"net_include"`
+	NetExclude  []string `env:"NET_EXCLUDE" envSeparator:"," json:"net_exclude"`
+	// Processes watched by the process collector, can be set only in JSON configuration.
+	Processes []ProcessTarget `json:"processes"`
+	// Local address for applications to push custom metrics to, either loopback host:port or unix:/path.
+	PushAddress string `env:"PUSH_ADDRESS" json:"push_address"`
+	// Address of the local status endpoints, either loopback host:port or unix:/path, disabled when empty.
+	StatusAddress string `env:"STATUS_ADDRESS" json:"status_address"`
+	// Endpoints scraped by the scrape collector, can be set only in JSON configuration.
+	Scrapes []ScrapeTarget `json:"scrapes"`
+	// Rules applied to the names of collected metrics in order, can be set only in JSON configuration.
+	Relabel []RelabelRule `json:"relabel"`
+	// Print effects of the relabel rules on the collected metrics and exit.
+	RelabelDryRun bool `env:"RELABEL_DRY_RUN" json:"-"`
+	// Aggregation of gauge samples within the report interval, can be set only in JSON configuration.
+	// The first rule matching the gauge applies, gauges matching none of them keep the last sample.
+	Aggregations []Aggregation `json:"aggregations"`
+	// Maximum number of gauges aggregated within the report interval, the rest keep the last sample.
+	AggregationMaxSeries int `env:"AGGREGATION_MAX_SERIES" json:"aggregation_max_series"`
+	// Log files tailed by the log collector, can be set only in JSON configuration.
+	LogTails []LogTailTarget `json:"log_tails"`
+	// File keeping read offsets of the tailed log files between restarts.
+	LogTailState string `env:"LOG_TAIL_STATE" json:"log_tail_state"`
+	// Mount point of the cgroup v2 hierarchy read by the cgroup collector.
+	CgroupRoot string `env:"CGROUP_ROOT" json:"cgroup_root"`
+	// Directory of the queue of metrics undelivered due to server outage, queue is disabled when empty.
+	OutboxDir string `env:"OUTBOX_DIR" json:"outbox_dir"`
+	// Maximum size of the queue in megabytes and maximum age of the queued metrics in seconds.
+	OutboxMaxSize int `env:"OUTBOX_MAX_SIZE" json:"outbox_max_size"`
+	OutboxMaxAge  int `env:"OUTBOX_MAX_AGE" json:"outbox_max_age"`
+	// Number of consecutive delivery failures opening the circuit breaker
+	// and cool-down in seconds before the server is probed again.
+	BreakerThreshold int `env:"BREAKER_THRESHOLD" json:"breaker_threshold"`
+	BreakerCooldown  int `env:"BREAKER_COOLDOWN" json:"breaker_cooldown"`
+	// Maximum number of metrics and maximum size in bytes of compressed request body of a batch.
+	BatchMaxCount int `env:"BATCH_MAX_COUNT" json:"batch_max_count"`
+	BatchMaxBytes int `env:"BATCH_MAX_BYTES" json:"batch_max_bytes"`
+	// Seconds between negotiations of the transport with the server.
+	NegotiateInterval int `env:"NEGOTIATE_INTERVAL" json:"negotiate_interval"`
+	// Identity of the agent used to resolve its settings served by the server, host name by default.
+	AgentID    string `env:"AGENT_ID" json:"agent_id"`
+	AgentGroup string `env:"AGENT_GROUP" json:"agent_group"`
+	// Seconds between polls of the settings served by the server, polling is disabled when zero.
+	RemoteConfigInterval int `env:"REMOTE_CONFIG_INTERVAL" json:"remote_config_interval"`
+	// Names of the settings which are not changed by the server: report_interval, poll_interval, rate_limit.
+	LockedSettings []string `env:"LOCKED_SETTINGS" envSeparator:"," json:"locked_settings"`
+	// Seconds given to the final delivery of metrics on shutdown.
+	ShutdownTimeout int    `env:"SHUTDOWN_TIMEOUT" json:"shutdown_timeout"`
+	Config          string `env:"CONFIG"`
+}
+
+// ProcessTarget describes how to find the process to watch.
+// Process is looked up by PID file if specified, otherwise by exact name and/or command line pattern.
+type ProcessTarget struct {
+	PIDFile string `json:"pid_file"`
+	Name    string `json:"name"`
+	Cmdline string `json:"cmdline"`
+	// Prefix of the metric names, process name is used by default.
+	Prefix string `json:"prefix"`
+}
+
+// Delivery modes of the destinations.
+const (
+	// DestinationFailover destinations form a group in which metrics are delivered to the first available one.
+	DestinationFailover = "failover"
+	// DestinationMirror destination receives all metrics independently of the other destinations.
+	DestinationMirror = "mirror"
+)
+
+// Destination describes the metric server the agent delivers metrics to.
+type Destination struct {
+	// Name used in logs and agent's own metrics, address by default.
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	// Delivery mode, either "failover" or "mirror", failover by default.
+	Mode string `json:"mode"`
+	// Secret hash key and path to public key of the server, agent's ones are used when empty.
+	HashKey   string `json:"key"`
+	PublicKey string `json:"public_key"`
+}
+
+// RelabelRule filters or transforms collected metrics by their names.
+type RelabelRule struct {
+	// Action is one of "keep", "drop", "replace", "rename" and "label-add".
+	Action string `json:"action"`
+	// Regular expression matching the whole metric name, any name matches when empty.
+	// Replace action substitutes every match within the name instead.
+	Regex string `json:"regex"`
+	// Type of the metrics the rule applies to, either "gauge" or "counter", all metrics when empty.
+	Type string `json:"type"`
+	// New name or value of the added label. Groups of the regular expression are referred to as $1,
+	// host name and identity of the agent as ${hostname} and ${agent_id}.
+	Replacement string `json:"replacement"`
+	// Name of the label added by label-add action, labels are appended to the metric name.
+	Label string `json:"label"`
+}
+
+// Aggregation selects how samples of the gauges collected within the report interval are reported.
+type Aggregation struct {
+	// Regular expression matching the whole gauge name.
+	Regex string `json:"regex"`
+	// Modes are "min", "max", "avg" and "last". The single mode is reported under the gauge name,
+	// several ones as separate gauges with the mode appended to the name, e.g. "Alloc_max".
+	Modes []string `json:"modes"`
+}
+
+// ScrapeTarget describes the endpoint of local application exposing its metrics.
+type ScrapeTarget struct {
+	URL string `json:"url"`
+	// Format of the exposed metrics, either "expvar" or "prometheus".
+	Format string `json:"format"`
+	// Prefix prepended to the metric names.
+	Prefix string `json:"prefix"`
+	// Regular expressions of the metric names to relay and to skip.
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+	// Interval of scraping in seconds, poll interval is used when it is shorter.
+	Interval int `json:"interval"`
+}
+
+// LogTailTarget describes the log file to tail and the rules applied to its lines.
+type LogTailTarget struct {
+	Path  string    `json:"path"`
+	Rules []LogRule `json:"rules"`
+}
+
+// LogRule increments the counter for every log line matching the pattern.
+// When Gauges is set, values of the named capture groups are reported as gauges named after the groups.
+type LogRule struct {
+	Pattern string `json:"pattern"`
+	Counter string `json:"counter"`
+	Gauges  bool   `json:"gauges"`
 }
 
 func (cfg *AgentConfig) Merge(cfgMerge *AgentConfig) {
@@ -86,30 +373,324 @@ func (cfg *AgentConfig) Merge(cfgMerge *AgentConfig) {
 	if cfg.ReportInterval == -1 {
 		cfg.ReportInterval = cfgMerge.ReportInterval
 	}
+	if cfg.TLSCA == "" {
+		cfg.TLSCA = cfgMerge.TLSCA
+	}
+	if cfg.TLSCert == "" {
+		cfg.TLSCert = cfgMerge.TLSCert
+	}
+	if cfg.TLSKey == "" {
+		cfg.TLSKey = cfgMerge.TLSKey
+	}
+	if len(cfg.Collectors) == 0 {
+		cfg.Collectors = cfgMerge.Collectors
+	}
+	if len(cfg.DiskInclude) == 0 {
+		cfg.DiskInclude = cfgMerge.DiskInclude
+	}
+	if len(cfg.DiskExclude) == 0 {
+		cfg.DiskExclude = cfgMerge.DiskExclude
+	}
+	if len(cfg.NetInclude) == 0 {
+		cfg.NetInclude = cfgMerge.NetInclude
+	}
+	if len(cfg.NetExclude) == 0 {
+		cfg.NetExclude = cfgMerge.NetExclude
+	}
+	if len(cfg.Processes) == 0 {
+		cfg.Processes = cfgMerge.Processes
+	}
+	if cfg.PushAddress == "" {
+		cfg.PushAddress = cfgMerge.PushAddress
+	}
+	if cfg.StatusAddress == "" {
+		cfg.StatusAddress = cfgMerge.StatusAddress
+	}
+	if len(cfg.Destinations) == 0 {
+		cfg.Destinations = cfgMerge.Destinations
+	}
+	if len(cfg.Relabel) == 0 {
+		cfg.Relabel = cfgMerge.Relabel
+	}
+	if len(cfg.Aggregations) == 0 {
+		cfg.Aggregations = cfgMerge.Aggregations
+	}
+	if cfg.AggregationMaxSeries == 0 {
+		cfg.AggregationMaxSeries = cfgMerge.AggregationMaxSeries
+	}
+	if len(cfg.Scrapes) == 0 {
+		cfg.Scrapes = cfgMerge.Scrapes
+	}
+	if len(cfg.LogTails) == 0 {
+		cfg.LogTails = cfgMerge.LogTails
+	}
+	if cfg.LogTailState == "" {
+		cfg.LogTailState = cfgMerge.LogTailState
+	}
+	if cfg.CgroupRoot == "" {
+		cfg.CgroupRoot = cfgMerge.CgroupRoot
+	}
+	if cfg.OutboxDir == "" {
+		cfg.OutboxDir = cfgMerge.OutboxDir
+	}
+	if cfg.OutboxMaxSize == 0 {
+		cfg.OutboxMaxSize = cfgMerge.OutboxMaxSize
+	}
+	if cfg.OutboxMaxAge == 0 {
+		cfg.OutboxMaxAge = cfgMerge.OutboxMaxAge
+	}
+	if cfg.BreakerThreshold == 0 {
+		cfg.BreakerThreshold = cfgMerge.BreakerThreshold
+	}
+	if cfg.BreakerCooldown == 0 {
+		cfg.BreakerCooldown = cfgMerge.BreakerCooldown
+	}
+	if cfg.NegotiateInterval == 0 {
+		cfg.NegotiateInterval = cfgMerge.NegotiateInterval
+	}
+	if cfg.ShutdownTimeout == 0 {
+		cfg.ShutdownTimeout = cfgMerge.ShutdownTimeout
+	}
+	if cfg.BatchMaxCount == 0 {
+		cfg.BatchMaxCount = cfgMerge.BatchMaxCount
+	}
+	if cfg.BatchMaxBytes == 0 {
+		cfg.BatchMaxBytes = cfgMerge.BatchMaxBytes
+	}
+	if cfg.AgentID == "" {
+		cfg.AgentID = cfgMerge.AgentID
+	}
+	if cfg.AgentGroup == "" {
+		cfg.AgentGroup = cfgMerge.AgentGroup
+	}
+	if cfg.RemoteConfigInterval == 0 {
+		cfg.RemoteConfigInterval = cfgMerge.RemoteConfigInterval
+	}
+	if len(cfg.LockedSettings) == 0 {
+		cfg.LockedSettings = cfgMerge.LockedSettings
+	}
+}
+
+// EnabledCollectors returns names of the collectors to run.
+func (cfg *AgentConfig) EnabledCollectors() []string {
+	if len(cfg.Collectors) == 0 {
+		return []string{"memstats", "virtual_memory", "cpu"}
+	}
+	return cfg.Collectors
+}
+
+// Redacted returns copy of the configuration with the secrets and paths to the keys and certificates hidden.
+func (cfg *AgentConfig) Redacted() AgentConfig {
+	c := *cfg
+	for _, secret := range []*string{&c.HashKey, &c.PublicKey, &c.TLSCA, &c.TLSCert, &c.TLSKey} {
+		redact(secret)
+	}
+	c.Destinations = slices.Clone(c.Destinations)
+	for i := range c.Destinations {
+		redact(&c.Destinations[i].HashKey)
+		redact(&c.Destinations[i].PublicKey)
+	}
+	return c
+}
+
+func redact(s *string) {
+	if *s != "" {
+		*s = "[redacted]"
+	}
+}
+
+// GetDestinations returns metric servers with the defaults applied.
+// Single failover destination is made of the agent's address and keys when none is configured.
+func (cfg *AgentConfig) GetDestinations() []Destination {
+	if len(cfg.Destinations) == 0 {
+		return []Destination{{
+			Name:      cfg.Address,
+			Address:   cfg.Address,
+			Mode:      DestinationFailover,
+			HashKey:   cfg.HashKey,
+			PublicKey: cfg.PublicKey,
+		}}
+	}
+	dests := slices.Clone(cfg.Destinations)
+	for i := range dests {
+		if dests[i].Name == "" {
+			dests[i].Name = dests[i].Address
+		}
+		if dests[i].Mode == "" {
+			dests[i].Mode = DestinationFailover
+		}
+		if dests[i].HashKey == "" {
+			dests[i].HashKey = cfg.HashKey
+		}
+		if dests[i].PublicKey == "" {
+			dests[i].PublicKey = cfg.PublicKey
+		}
+	}
+	return dests
+}
+
+// ServerURL returns base URL of the metric server.
+// Address without scheme is served over HTTPS when any of TLS options is set.
+func (cfg *AgentConfig) ServerURL() string {
+	return cfg.DestinationURL(cfg.Address)
+}
+
+// DestinationURL returns base URL of the metric server at the address.
+func (cfg *AgentConfig) DestinationURL(address string) string {
+	if strings.Contains(address, "://") {
+		return strings.TrimSuffix(address, "/")
+	}
+	if cfg.TLSCA != "" || cfg.TLSCert != "" {
+		return "https://" + address
+	}
+	return "http://" + address
+}
+
+// LoadTLSConfig returns client TLS configuration or nil if TLS options are not set.
+// Server certificate is verified against the CA bundle when specified and
+// client certificate is presented for mutual authentication. Files are reloaded on modification.
+func (cfg *AgentConfig) LoadTLSConfig() (*tls.Config, error) {
+	if cfg.TLSCA == "" && cfg.TLSCert == "" {
+		return nil, nil
+	}
+	tlsConf := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.TLSCA != "" {
+		ca, err := util.NewCertPoolReloader(cfg.TLSCA)
+		if err != nil {
+			return nil, err
+		}
+		// Verification is done manually to pick up CA bundle changes.
+		tlsConf.InsecureSkipVerify = true
+		tlsConf.VerifyConnection = ca.VerifyConnection
+	}
+	if cfg.TLSCert != "" {
+		certs, err := util.NewCertificateReloader(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsConf.GetClientCertificate = certs.GetClientCertificate
+	}
+	return tlsConf, nil
 }
 
+// GetReportInterval returns interval of sending metrics to the server, 10 seconds by default.
 func (cfg *AgentConfig) GetReportInterval() time.Duration {
+	if cfg.ReportInterval <= 0 {
+		return 10 * time.Second
+	}
 	return time.Second * time.Duration(cfg.ReportInterval)
 }
 
+// GetPollInterval returns interval of collecting metrics, 2 seconds by default.
 func (cfg *AgentConfig) GetPollInterval() time.Duration {
+	if cfg.PollInterval <= 0 {
+		return 2 * time.Second
+	}
 	return time.Second * time.Duration(cfg.PollInterval)
 }
 
+// GetShutdownTimeout returns time given to the final delivery of metrics, 5 seconds by default.
+func (cfg *AgentConfig) GetShutdownTimeout() time.Duration {
+	if cfg.ShutdownTimeout <= 0 {
+		return 5 * time.Second
+	}
+	return time.Second * time.Duration(cfg.ShutdownTimeout)
+}
+
+// GetBreakerThreshold returns number of failures opening the circuit breaker, 5 by default.
+func (cfg *AgentConfig) GetBreakerThreshold() int {
+	if cfg.BreakerThreshold <= 0 {
+		return 5
+	}
+	return cfg.BreakerThreshold
+}
+
+// GetBreakerCooldown returns time the circuit breaker stays open, 30 seconds by default.
+func (cfg *AgentConfig) GetBreakerCooldown() time.Duration {
+	if cfg.BreakerCooldown <= 0 {
+		return 30 * time.Second
+	}
+	return time.Second * time.Duration(cfg.BreakerCooldown)
+}
+
+// GetNegotiateInterval returns interval between negotiations of the transport, one minute by default.
+func (cfg *AgentConfig) GetNegotiateInterval() time.Duration {
+	if cfg.NegotiateInterval <= 0 {
+		return time.Minute
+	}
+	return time.Second * time.Duration(cfg.NegotiateInterval)
+}
+
+// GetAgentID returns identity of the agent, host name by default.
+func (cfg *AgentConfig) GetAgentID() string {
+	if cfg.AgentID != "" {
+		return cfg.AgentID
+	}
+	host, _ := os.Hostname()
+	return host
+}
+
+// GetRemoteConfigInterval returns interval between polls of the settings served by the server.
+func (cfg *AgentConfig) GetRemoteConfigInterval() time.Duration {
+	return time.Second * time.Duration(cfg.RemoteConfigInterval)
+}
+
+// GetBatchMaxCount returns maximum number of metrics in a batch, 100 by default.
+func (cfg *AgentConfig) GetBatchMaxCount() int {
+	if cfg.BatchMaxCount <= 0 {
+		return 100
+	}
+	return cfg.BatchMaxCount
+}
+
+// GetBatchMaxBytes returns maximum size of compressed request body of a batch, 64 KB by default.
+func (cfg *AgentConfig) GetBatchMaxBytes() int {
+	if cfg.BatchMaxBytes <= 0 {
+		return 64 << 10
+	}
+	return cfg.BatchMaxBytes
+}
+
+// GetAggregationMaxSeries returns maximum number of gauges aggregated within the report interval, 1000 by default.
+func (cfg *AgentConfig) GetAggregationMaxSeries() int {
+	if cfg.AggregationMaxSeries <= 0 {
+		return 1000
+	}
+	return cfg.AggregationMaxSeries
+}
+
+// GetOutboxMaxSize returns maximum size of the outbox in bytes, 64 MB by default.
+func (cfg *AgentConfig) GetOutboxMaxSize() int64 {
+	if cfg.OutboxMaxSize <= 0 {
+		return 64 << 20
+	}
+	return int64(cfg.OutboxMaxSize) << 20
+}
+
+// GetOutboxMaxAge returns maximum age of the queued metrics, one day by default.
+func (cfg *AgentConfig) GetOutboxMaxAge() time.Duration {
+	if cfg.OutboxMaxAge <= 0 {
+		return 24 * time.Hour
+	}
+	return time.Second * time.Duration(cfg.OutboxMaxAge)
+}
+
 func NewServerConfig() *ServerConfig {
 	return &ServerConfig{
-		Address:      "localhost:8080",
-		SaveInterval: 300,
-		StoragePath:  "/metrics.dat",
-		Restore:      true,
+		Address:        "localhost:8080",
+		SaveInterval:   300,
+		StoragePath:    "/metrics.dat",
+		Restore:        true,
+		IdempotencyTTL: 86400,
+		MaxBatchSize:   1000,
 	}
 }
 
 func NewAgentConfig() *AgentConfig {
 	return &AgentConfig{
 		Address:        "localhost:8080",
-		ReportInterval: 2,
-		PollInterval:   10,
+		ReportInterval: 10,
+		PollInterval:   2,
 		RateLimit:      1,
 	}
 }
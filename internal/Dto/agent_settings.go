@@ -0,0 +1,23 @@
+package dto
+
+// AgentSettings is the part of agent configuration which can be changed at runtime.
+// Nil fields are left unchanged.
+type AgentSettings struct {
+	ReportInterval *int `json:"report_interval,omitempty"`
+	PollInterval   *int `json:"poll_interval,omitempty"`
+	RateLimit      *int `json:"rate_limit,omitempty"`
+}
+
+// Overlay returns settings with the fields set in the other settings replaced.
+func (s AgentSettings) Overlay(other AgentSettings) AgentSettings {
+	if other.ReportInterval != nil {
+		s.ReportInterval = other.ReportInterval
+	}
+	if other.PollInterval != nil {
+		s.PollInterval = other.PollInterval
+	}
+	if other.RateLimit != nil {
+		s.RateLimit = other.RateLimit
+	}
+	return s
+}
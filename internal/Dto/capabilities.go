@@ -0,0 +1,28 @@
+package dto
+
+// Values of the server capabilities.
+const (
+	APIVersion = "1"
+
+	EncodingPlain = "plain"
+	EncodingJSON  = "json"
+
+	CompressionGzip = "gzip"
+
+	SigningSHA256 = "sha256"
+
+	EncryptionRSAOAEP = "rsa-oaep-sha256"
+)
+
+// Capabilities describes protocols supported by the metric server.
+type Capabilities struct {
+	APIVersion  string   `json:"api_version"`
+	Encodings   []string `json:"encodings"`
+	Compression []string `json:"compression"`
+	Signing     []string `json:"signing"`
+	Encryption  []string `json:"encryption"`
+	// Batch reports whether /updates endpoint is available.
+	Batch bool `json:"batch"`
+	// MaxBatchSize is the maximum number of metrics in a batch, zero means no limit.
+	MaxBatchSize int `json:"max_batch_size"`
+}
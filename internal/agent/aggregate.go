@@ -0,0 +1,177 @@
+package agent
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"sync"
+
+	"github.com/Jeskay/musthave_metrics/config"
+	dto "github.com/Jeskay/musthave_metrics/internal/Dto"
+)
+
+// Modes of the gauge aggregation.
+const (
+	aggregateMin  = "min"
+	aggregateMax  = "max"
+	aggregateAvg  = "avg"
+	aggregateLast = "last"
+)
+
+const aggregationOverflowMetric = "AgentAggregationOverflow"
+
+type aggregationRule struct {
+	re    *regexp.Regexp
+	modes []string
+}
+
+// window accumulates samples of the gauge within the report interval.
+// Only the running values are kept, so memory used by the gauge does not depend on the number of samples.
+type window struct {
+	modes []string
+	min   float64
+	max   float64
+	sum   float64
+	last  float64
+	count int
+}
+
+func (w *window) add(v float64) {
+	if w.count == 0 {
+		w.min, w.max = v, v
+	}
+	w.min = math.Min(w.min, v)
+	w.max = math.Max(w.max, v)
+	w.sum += v
+	w.last = v
+	w.count++
+}
+
+func (w *window) value(mode string) float64 {
+	switch mode {
+	case aggregateMin:
+		return w.min
+	case aggregateMax:
+		return w.max
+	case aggregateAvg:
+		return w.sum / float64(w.count)
+	default:
+		return w.last
+	}
+}
+
+// aggregator computes the gauges reported for the samples collected within the report interval.
+// The number of aggregated gauges is limited, samples of the others are stored as is.
+// A gauge keeps its slot while it is sampled, so it is reported under the same names every interval.
+type aggregator struct {
+	rules     []aggregationRule
+	maxSeries int
+	mu        sync.Mutex
+	windows   map[string]*window
+	overflow  int64
+}
+
+// newAggregator compiles the aggregation rules.
+func newAggregator(rules []config.Aggregation, maxSeries int) (*aggregator, error) {
+	a := &aggregator{
+		rules:     make([]aggregationRule, 0, len(rules)),
+		maxSeries: maxSeries,
+		windows:   make(map[string]*window),
+	}
+	for i, rule := range rules {
+		if len(rule.Modes) == 0 {
+			return nil, fmt.Errorf("aggregation rule %d: no modes", i)
+		}
+		for j, mode := range rule.Modes {
+			switch mode {
+			case aggregateMin, aggregateMax, aggregateAvg, aggregateLast:
+			default:
+				return nil, fmt.Errorf("aggregation rule %d: unknown mode %q", i, mode)
+			}
+			if slices.Contains(rule.Modes[:j], mode) {
+				return nil, fmt.Errorf("aggregation rule %d: duplicate mode %q", i, mode)
+			}
+		}
+		re, err := regexp.Compile("^(?:" + rule.Regex + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("aggregation rule %d: %w", i, err)
+		}
+		a.rules = append(a.rules, aggregationRule{re: re, modes: rule.Modes})
+	}
+	return a, nil
+}
+
+// Add accumulates the gauge sample and reports whether it is aggregated.
+// Samples of the gauges not matching the rules or exceeding the limit are not aggregated.
+func (a *aggregator) Add(m dto.Metrics) bool {
+	if a == nil || len(a.rules) == 0 || m.Value == nil {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	w, ok := a.windows[m.ID]
+	if !ok {
+		i := slices.IndexFunc(a.rules, func(r aggregationRule) bool { return r.re.MatchString(m.ID) })
+		if i < 0 {
+			return false
+		}
+		if len(a.windows) >= a.maxSeries {
+			a.overflow++
+			return false
+		}
+		w = &window{modes: a.rules[i].modes}
+		a.windows[m.ID] = w
+	}
+	w.add(*m.Value)
+	return true
+}
+
+// Flush returns the gauges computed over the samples of the report interval and starts the next one.
+// Slots of the gauges not sampled within the interval are released.
+func (a *aggregator) Flush() []dto.Metrics {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	metrics := make([]dto.Metrics, 0, len(a.windows))
+	for name, w := range a.windows {
+		if w.count == 0 {
+			delete(a.windows, name)
+			continue
+		}
+		if len(w.modes) == 1 {
+			metrics = append(metrics, dto.NewGaugeMetrics(name, w.value(w.modes[0])))
+		} else {
+			for _, mode := range w.modes {
+				metrics = append(metrics, dto.NewGaugeMetrics(name+"_"+mode, w.value(mode)))
+			}
+		}
+		*w = window{modes: w.modes}
+	}
+	return metrics
+}
+
+// TakeOverflow returns the number of samples not aggregated due to the limit since the previous call.
+func (a *aggregator) TakeOverflow() int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	n := a.overflow
+	a.overflow = 0
+	return n
+}
+
+// flushAggregates stores the gauges aggregated over the report interval
+// and the number of samples not aggregated due to the limit as agent's own metric.
+func (svc *AgentService) flushAggregates() {
+	if svc.aggregate == nil || len(svc.aggregate.rules) == 0 {
+		return
+	}
+	if err := svc.storage.SetMany(svc.aggregate.Flush()); err != nil {
+		svc.logger.Error(err.Error())
+	}
+	if overflow := svc.aggregate.TakeOverflow(); overflow > 0 {
+		svc.counters.Add(aggregationOverflowMetric, overflow)
+	}
+}
@@ -0,0 +1,95 @@
+package agent
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Jeskay/musthave_metrics/config"
+	dto "github.com/Jeskay/musthave_metrics/internal/Dto"
+)
+
+func TestAggregator(t *testing.T) {
+	a, err := newAggregator([]config.Aggregation{
+		{Regex: "Heap.*", Modes: []string{"min", "max", "avg"}},
+		{Regex: "Alloc", Modes: []string{"max"}},
+	}, 2)
+	require.NoError(t, err)
+
+	for _, v := range []float64{3, 9, 6} {
+		assert.True(t, a.Add(dto.NewGaugeMetrics("HeapInuse", v)))
+		assert.True(t, a.Add(dto.NewGaugeMetrics("Alloc", v)))
+	}
+	assert.False(t, a.Add(dto.NewGaugeMetrics("StackSys", 1)), "gauge matching no rule is not aggregated")
+	assert.False(t, a.Add(dto.NewGaugeMetrics("HeapSys", 1)), "gauge beyond the limit is not aggregated")
+
+	assert.Equal(t, map[string]float64{
+		"HeapInuse_min": 3,
+		"HeapInuse_max": 9,
+		"HeapInuse_avg": 6,
+		"Alloc":         9,
+	}, gaugeValues(a.Flush()))
+	assert.Equal(t, int64(1), a.TakeOverflow())
+	assert.Zero(t, a.TakeOverflow())
+
+	assert.True(t, a.Add(dto.NewGaugeMetrics("HeapInuse", 5)))
+	assert.False(t, a.Add(dto.NewGaugeMetrics("HeapSys", 4)), "slots are kept by the aggregated gauges")
+	assert.Equal(t, map[string]float64{"HeapInuse_min": 5, "HeapInuse_max": 5, "HeapInuse_avg": 5}, gaugeValues(a.Flush()),
+		"gauge is aggregated in the next window")
+	assert.Equal(t, int64(1), a.TakeOverflow())
+
+	assert.True(t, a.Add(dto.NewGaugeMetrics("HeapSys", 4)), "slot of the gauge not sampled in the window is released")
+	assert.Equal(t, map[string]float64{"HeapSys_min": 4, "HeapSys_max": 4, "HeapSys_avg": 4}, gaugeValues(a.Flush()))
+	assert.Empty(t, a.Flush())
+}
+
+func TestAggregatorInvalid(t *testing.T) {
+	rules := [][]config.Aggregation{
+		{{Regex: "Alloc"}},
+		{{Regex: "Alloc", Modes: []string{"median"}}},
+		{{Regex: "Alloc", Modes: []string{"max", "max"}}},
+		{{Regex: "(", Modes: []string{"max"}}},
+	}
+	for _, r := range rules {
+		_, err := newAggregator(r, 10)
+		assert.Error(t, err)
+	}
+}
+
+func TestCollectAggregated(t *testing.T) {
+	conf := &config.AgentConfig{
+		Address:      "localhost:3000",
+		Aggregations: []config.Aggregation{{Regex: "Alloc", Modes: []string{"max"}}},
+	}
+	svc := NewAgentService(http.DefaultClient, conf, slog.NewTextHandler(os.Stdout, nil))
+	for _, v := range []float64{5, 20, 10} {
+		svc.Collect(context.Background(), &stubCollector{metrics: []dto.Metrics{dto.NewGaugeMetrics("Alloc", v), dto.NewGaugeMetrics("Sys", v)}})
+	}
+	_, ok := svc.storage.Get("Alloc")
+	assert.False(t, ok, "aggregated gauge is stored on report")
+	m, ok := svc.storage.Get("Sys")
+	require.True(t, ok)
+	assert.Equal(t, 10.0, *m.Value)
+
+	svc.flushAggregates()
+	m, ok = svc.storage.Get("Alloc")
+	require.True(t, ok)
+	assert.Equal(t, 20.0, *m.Value)
+	_, ok = svc.counters.Get(aggregationOverflowMetric)
+	assert.False(t, ok, "overflow is not reported without samples beyond the limit")
+}
+
+func TestRunInvalidAggregationRules(t *testing.T) {
+	conf := &config.AgentConfig{
+		Address:      "localhost:3000",
+		Aggregations: []config.Aggregation{{Regex: "Alloc", Modes: []string{"median"}}},
+	}
+	svc := NewAgentService(http.DefaultClient, conf, slog.NewTextHandler(os.Stdout, nil))
+	svc.clock = newFakeClock()
+	assert.ErrorContains(t, svc.Run(context.Background()), "invalid aggregation rules", "agent does not start with invalid rules")
+}
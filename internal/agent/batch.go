@@ -0,0 +1,71 @@
+package agent
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/Jeskay/musthave_metrics/pkg/retry"
+)
+
+const (
+	// batchGrowAfter is the number of deliveries in a row after which shrunk batch limits are doubled.
+	batchGrowAfter = 5
+	// maxBatchShrink is the maximum number of times batch limits are halved.
+	maxBatchShrink = 10
+	// minBatchBytes is the lower bound of the shrunk size limit.
+	minBatchBytes = 1 << 10
+)
+
+// batchLimiter adapts limits of the batches to the server. Limits are halved each time
+// the server rejects a batch as too large and doubled back after sustained successful delivery.
+type batchLimiter struct {
+	mu        sync.Mutex
+	shrink    int
+	successes int
+}
+
+// limits returns the maximum number of metrics and the maximum request body size of a batch.
+func (l *batchLimiter) limits(maxCount, maxBytes int) (count, size int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	count = max(maxCount>>l.shrink, 1)
+	size = maxBytes >> l.shrink
+	if size < minBatchBytes {
+		size = min(maxBytes, minBatchBytes)
+	}
+	return count, size
+}
+
+// tooLarge halves the limits. It returns false if the limits can not be shrunk anymore.
+func (l *batchLimiter) tooLarge() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.successes = 0
+	if l.shrink >= maxBatchShrink {
+		return false
+	}
+	l.shrink++
+	return true
+}
+
+// success records delivered batch and reports whether the limits are doubled.
+func (l *batchLimiter) success() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.shrink == 0 {
+		return false
+	}
+	l.successes++
+	if l.successes < batchGrowAfter {
+		return false
+	}
+	l.successes = 0
+	l.shrink--
+	return true
+}
+
+func isTooLarge(err error) bool {
+	var status *retry.StatusError
+	return errors.As(err, &status) && status.Code == http.StatusRequestEntityTooLarge
+}
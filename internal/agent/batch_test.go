@@ -0,0 +1,130 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Jeskay/musthave_metrics/config"
+	dto "github.com/Jeskay/musthave_metrics/internal/Dto"
+)
+
+func TestBatchLimiter(t *testing.T) {
+	var l batchLimiter
+	count, size := l.limits(100, 64<<10)
+	assert.Equal(t, 100, count)
+	assert.Equal(t, 64<<10, size)
+
+	assert.True(t, l.tooLarge())
+	assert.True(t, l.tooLarge())
+	count, size = l.limits(100, 64<<10)
+	assert.Equal(t, 25, count)
+	assert.Equal(t, 16<<10, size)
+
+	for i := 1; i < batchGrowAfter; i++ {
+		assert.False(t, l.success())
+	}
+	assert.True(t, l.success())
+	count, _ = l.limits(100, 64<<10)
+	assert.Equal(t, 50, count)
+
+	for l.tooLarge() {
+	}
+	count, size = l.limits(100, 64<<10)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, minBatchBytes, size)
+}
+
+func batchService(t *testing.T, count int) *AgentService {
+	conf := &config.AgentConfig{Address: "localhost:3000"}
+	svc := NewAgentService(http.DefaultClient, conf, slog.NewTextHandler(os.Stdout, nil))
+	for i := 0; i < count; i++ {
+		svc.storage.Set(dto.NewGaugeMetrics(fmt.Sprintf("Gauge%d", i), float64(i)))
+	}
+	return svc
+}
+
+func prepareBatches(svc *AgentService, maxCount, maxBytes int) []*report {
+	reqs := make(chan *report)
+	go func() {
+		svc.PrepareMetricsBatch(svc.routes[0], svc.metricNames(svc.routes[0]), reqs, maxCount, maxBytes)
+		close(reqs)
+	}()
+	var reports []*report
+	for r := range reqs {
+		reports = append(reports, r)
+	}
+	return reports
+}
+
+func TestPrepareMetricsBatchLimits(t *testing.T) {
+	svc := batchService(t, 10)
+	svc.counters.Add("PollCount", 1)
+	var sizes []int
+	for _, r := range prepareBatches(svc, 4, 64<<10) {
+		sizes = append(sizes, len(r.metrics))
+	}
+	assert.Equal(t, []int{4, 4, 3}, sizes, "batches are filled up to the count regardless of the position of the metric")
+
+	svc = batchService(t, 64)
+	whole := prepareBatches(svc, 100, 64<<10)
+	require.Len(t, whole, 1)
+	limit := int(whole[0].req.ContentLength/2) + 1
+	total := 0
+	for _, r := range prepareBatches(svc, 100, limit) {
+		assert.LessOrEqual(t, r.req.ContentLength, int64(limit))
+		total += len(r.metrics)
+	}
+	assert.Equal(t, 64, total)
+}
+
+func TestSendMetricsTooLarge(t *testing.T) {
+	var strict atomic.Bool
+	strict.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metrics, ok := decodeMetrics(t, r)
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if strict.Load() && len(metrics) > 4 {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+		}
+	}))
+	defer server.Close()
+
+	conf := &config.AgentConfig{Address: server.URL, RateLimit: 1, BatchMaxCount: 8}
+	svc := NewAgentService(server.Client(), conf, slog.NewTextHandler(os.Stdout, nil))
+	d := svc.destinations[0]
+	send := func() {
+		for i := 0; i < 8; i++ {
+			svc.storage.Set(dto.NewGaugeMetrics(fmt.Sprintf("Gauge%d", i), float64(i)))
+		}
+		count, size := svc.batchLimits(d)
+		reqs := make(chan *report, 2)
+		go func() {
+			svc.PrepareMetricsBatch(svc.routes[0], svc.metricNames(svc.routes[0]), reqs, count, size)
+			close(reqs)
+		}()
+		svc.SendMetrics(context.Background(), reqs)
+	}
+
+	send()
+	count, _ := svc.batchLimits(d)
+	assert.Equal(t, 4, count, "batches are shrunk when server rejects them")
+
+	strict.Store(false)
+	for i := 0; i < batchGrowAfter; i++ {
+		send()
+	}
+	count, _ = svc.batchLimits(d)
+	assert.Equal(t, 8, count, "batches grow back after sustained success")
+}
@@ -0,0 +1,133 @@
+package agent
+
+import (
+	"sync"
+	"time"
+)
+
+// Agent's own metrics of the circuit breaker. State is reported as 0 for closed,
+// 1 for half-open and 2 for open breaker.
+const (
+	breakerStateMetric       = "AgentBreakerState"
+	breakerTransitionsMetric = "AgentBreakerTransitions"
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerHalfOpen
+	breakerOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerClosed:
+		return "closed"
+	case breakerHalfOpen:
+		return "half-open"
+	case breakerOpen:
+		return "open"
+	}
+	return "unknown"
+}
+
+// circuitBreaker stops delivery to the server after the number of consecutive failures.
+// When the cool-down passes, the first caller probes the server in half-open state:
+// the breaker is closed if the probe succeeds and opened again otherwise.
+// Other callers are rejected while the breaker is not closed.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	probe     func() error
+	onChange  func(from, to breakerState)
+	now       func() time.Time
+	mu        sync.Mutex
+	state     breakerState
+	failures  int
+	openedAt  time.Time
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration, probe func() error, onChange func(from, to breakerState)) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		probe:     probe,
+		onChange:  onChange,
+		now:       time.Now,
+	}
+}
+
+// State returns current state of the breaker.
+func (b *circuitBreaker) State() breakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Allow reports whether the request can be sent to the server.
+func (b *circuitBreaker) Allow() bool {
+	b.mu.Lock()
+	switch b.state {
+	case breakerClosed:
+		b.mu.Unlock()
+		return true
+	case breakerOpen:
+		if b.now().Sub(b.openedAt) >= b.cooldown {
+			b.setState(breakerHalfOpen)
+			b.mu.Unlock()
+			return b.tryProbe()
+		}
+	}
+	b.mu.Unlock()
+	return false
+}
+
+// Success records successful delivery.
+func (b *circuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+}
+
+// Failure records failed delivery and opens the breaker when threshold is reached.
+func (b *circuitBreaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != breakerClosed {
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.open()
+	}
+}
+
+func (b *circuitBreaker) tryProbe() bool {
+	err := b.probe()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err != nil {
+		b.open()
+		return false
+	}
+	b.failures = 0
+	b.setState(breakerClosed)
+	return true
+}
+
+func (b *circuitBreaker) open() {
+	b.openedAt = b.now()
+	b.setState(breakerOpen)
+}
+
+func (b *circuitBreaker) setState(state breakerState) {
+	if b.state == state {
+		return
+	}
+	from := b.state
+	b.state = state
+	if b.onChange != nil {
+		b.onChange(from, state)
+	}
+}
@@ -0,0 +1,87 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Jeskay/musthave_metrics/config"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var probeErr error
+	probes := 0
+	var transitions []string
+	b := newCircuitBreaker(2, time.Minute, func() error {
+		probes++
+		return probeErr
+	}, func(from, to breakerState) {
+		transitions = append(transitions, from.String()+">"+to.String())
+	})
+	now := time.Now()
+	b.now = func() time.Time { return now }
+
+	assert.True(t, b.Allow())
+	b.Failure()
+	b.Success()
+	b.Failure()
+	assert.Equal(t, breakerClosed, b.State(), "failures must be consecutive")
+	b.Failure()
+	assert.Equal(t, breakerOpen, b.State())
+	assert.False(t, b.Allow())
+
+	now = now.Add(time.Minute)
+	probeErr = errors.New("server is down")
+	assert.False(t, b.Allow())
+	assert.Equal(t, breakerOpen, b.State())
+	assert.False(t, b.Allow(), "cool-down starts over after failed probe")
+
+	now = now.Add(time.Minute)
+	probeErr = nil
+	assert.True(t, b.Allow())
+	assert.Equal(t, breakerClosed, b.State())
+	assert.Equal(t, 2, probes)
+	assert.Equal(t, []string{
+		"closed>open",
+		"open>half-open", "half-open>open",
+		"open>half-open", "half-open>closed",
+	}, transitions)
+}
+
+func TestSendMetricsBreaker(t *testing.T) {
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	conf := &config.AgentConfig{Address: server.URL, RateLimit: 1, BreakerThreshold: 2, BreakerCooldown: 60}
+	svc := NewAgentService(server.Client(), conf, slog.NewTextHandler(os.Stdout, nil))
+	for i := 0; i < 4; i++ {
+		svc.counters.Add("PollCount", 1)
+		reqs := make(chan *report, 1)
+		go func() {
+			svc.PrepareMetricsBatch(svc.routes[0], []string{"PollCount"}, reqs, 8, 64<<10)
+			close(reqs)
+		}()
+		svc.SendMetrics(context.Background(), reqs)
+	}
+	assert.Equal(t, int64(2), requests.Load(), "requests are not sent while breaker is open")
+	assert.Equal(t, breakerOpen, svc.destinations[0].breaker.State())
+	m, ok := svc.counters.Get("PollCount")
+	require.True(t, ok)
+	assert.Equal(t, int64(4), *m.Delta)
+	transitions, ok := svc.counters.Get(breakerTransitionsMetric)
+	require.True(t, ok)
+	assert.Equal(t, int64(1), *transitions.Delta)
+}
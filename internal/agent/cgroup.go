@@ -0,0 +1,198 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	dto "github.com/Jeskay/musthave_metrics/internal/Dto"
+)
+
+const defaultCgroupRoot = "/sys/fs/cgroup"
+
+var errNoCgroupFiles = errors.New("no cgroup v2 files found")
+
+// cgroupCPUCounters maps cpu.stat keys to metric names.
+var cgroupCPUCounters = map[string]string{
+	"usage_usec":     "CgroupCPUUsageUsec",
+	"user_usec":      "CgroupCPUUserUsec",
+	"system_usec":    "CgroupCPUSystemUsec",
+	"nr_throttled":   "CgroupCPUThrottled",
+	"throttled_usec": "CgroupCPUThrottledUsec",
+}
+
+// cgroupIOCounters maps io.stat keys to metric names.
+var cgroupIOCounters = map[string]string{
+	"rbytes": "CgroupIOReadBytes",
+	"wbytes": "CgroupIOWriteBytes",
+	"rios":   "CgroupIOReadCount",
+	"wios":   "CgroupIOWriteCount",
+}
+
+// CgroupCollector reports resource usage of the cgroup v2 the agent runs in.
+// Memory usage, memory limit and number of processes are reported as gauges,
+// CPU time and per device IO statistics are reported as counters.
+// Files of the disabled controllers are skipped.
+type CgroupCollector struct {
+	interval time.Duration
+	root     string
+	mu       sync.Mutex
+	counters *cumulativeCounters
+}
+
+// NewCgroupCollector returns collector of the cgroup v2 mounted at the root, /sys/fs/cgroup by default.
+func NewCgroupCollector(interval time.Duration, root string) *CgroupCollector {
+	if root == "" {
+		root = defaultCgroupRoot
+	}
+	return &CgroupCollector{
+		interval: interval,
+		root:     root,
+		counters: newCumulativeCounters(),
+	}
+}
+
+func (c *CgroupCollector) Name() string { return "cgroup" }
+
+func (c *CgroupCollector) Interval() time.Duration { return c.interval }
+
+func (c *CgroupCollector) Collect(ctx context.Context) ([]dto.Metrics, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	metrics := make([]dto.Metrics, 0)
+	found := false
+	var errs []error
+	read := func(name string) ([]byte, bool) {
+		data, err := os.ReadFile(filepath.Join(c.root, name))
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+			return nil, false
+		}
+		found = true
+		return data, true
+	}
+	counter := func(name string, value uint64) {
+		if delta, ok := c.counters.Delta(name, value); ok {
+			metrics = append(metrics, dto.NewCounterMetrics(name, delta))
+		}
+	}
+
+	for file, metric := range map[string]string{
+		"memory.current": "CgroupMemoryCurrent",
+		"memory.max":     "CgroupMemoryMax",
+		"pids.current":   "CgroupPids",
+	} {
+		data, ok := read(file)
+		if !ok {
+			continue
+		}
+		value, limited, err := parseCgroupValue(data)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", file, err))
+			continue
+		}
+		if limited {
+			metrics = append(metrics, dto.NewGaugeMetrics(metric, float64(value)))
+		}
+	}
+	if data, ok := read("cpu.stat"); ok {
+		stat, err := parseCgroupFlatKeyed(data)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("cpu.stat: %w", err))
+		}
+		for key, metric := range cgroupCPUCounters {
+			if value, ok := stat[key]; ok {
+				counter(metric, value)
+			}
+		}
+	}
+	if data, ok := read("io.stat"); ok {
+		stat, err := parseCgroupNestedKeyed(data)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("io.stat: %w", err))
+		}
+		for device, values := range stat {
+			suffix := "_" + metricSuffix(device)
+			for key, metric := range cgroupIOCounters {
+				if value, ok := values[key]; ok {
+					counter(metric+suffix, value)
+				}
+			}
+		}
+	}
+	if !found && len(errs) == 0 {
+		return nil, fmt.Errorf("%w in %s", errNoCgroupFiles, c.root)
+	}
+	return metrics, errors.Join(errs...)
+}
+
+// parseCgroupValue parses single value file. Value "max" means there is no limit,
+// in which case limited is false.
+func parseCgroupValue(data []byte) (value uint64, limited bool, err error) {
+	s := strings.TrimSpace(string(data))
+	if s == "max" {
+		return 0, false, nil
+	}
+	value, err = strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, false, err
+	}
+	return value, true, nil
+}
+
+// parseCgroupFlatKeyed parses file of "key value" lines such as cpu.stat.
+func parseCgroupFlatKeyed(data []byte) (map[string]uint64, error) {
+	values := make(map[string]uint64)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return values, fmt.Errorf("invalid line %q", scanner.Text())
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return values, err
+		}
+		values[fields[0]] = value
+	}
+	return values, scanner.Err()
+}
+
+// parseCgroupNestedKeyed parses file of "name key=value ..." lines such as io.stat.
+func parseCgroupNestedKeyed(data []byte) (map[string]map[string]uint64, error) {
+	values := make(map[string]map[string]uint64)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		nested := make(map[string]uint64, len(fields)-1)
+		for _, field := range fields[1:] {
+			key, raw, ok := strings.Cut(field, "=")
+			if !ok {
+				return values, fmt.Errorf("invalid field %q", field)
+			}
+			value, err := strconv.ParseUint(raw, 10, 64)
+			if err != nil {
+				return values, err
+			}
+			nested[key] = value
+		}
+		values[fields[0]] = nested
+	}
+	return values, scanner.Err()
+}
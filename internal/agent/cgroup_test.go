@@ -0,0 +1,70 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCgroupParsing(t *testing.T) {
+	data, err := os.ReadFile("testdata/cgroup/cpu.stat")
+	require.NoError(t, err)
+	cpu, err := parseCgroupFlatKeyed(data)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1000000), cpu["usage_usec"])
+	assert.Equal(t, uint64(2), cpu["nr_throttled"])
+
+	data, err = os.ReadFile("testdata/cgroup/io.stat")
+	require.NoError(t, err)
+	io, err := parseCgroupNestedKeyed(data)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]uint64{"rbytes": 4096, "wbytes": 8192, "rios": 1, "wios": 2, "dbytes": 0, "dios": 0}, io["8:0"])
+	assert.Len(t, io, 2)
+
+	value, limited, err := parseCgroupValue([]byte("max\n"))
+	require.NoError(t, err)
+	assert.False(t, limited)
+	assert.Zero(t, value)
+	_, _, err = parseCgroupValue([]byte("unknown"))
+	assert.Error(t, err)
+}
+
+func TestCgroupCollector(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"memory.current", "memory.max", "pids.current", "cpu.stat", "io.stat"} {
+		data, err := os.ReadFile(filepath.Join("testdata/cgroup", name))
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(root, name), data, 0o644))
+	}
+	c := NewCgroupCollector(0, root)
+
+	metrics, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{
+		"CgroupMemoryCurrent": 104857600,
+		"CgroupMemoryMax":     536870912,
+		"CgroupPids":          12,
+	}, gaugeValues(metrics))
+	assert.Empty(t, counterValues(metrics))
+
+	require.NoError(t, os.WriteFile(filepath.Join(root, "memory.max"), []byte("max\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "cpu.stat"), []byte("usage_usec 1500000\nuser_usec 900000\nsystem_usec 600000\nnr_throttled 2\nthrottled_usec 5000\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "io.stat"), []byte("8:0 rbytes=8192 wbytes=8192 rios=2 wios=2\n"), 0o644))
+	metrics, err = c.Collect(context.Background())
+	require.NoError(t, err)
+	assert.NotContains(t, gaugeValues(metrics), "CgroupMemoryMax")
+	counters := counterValues(metrics)
+	assert.Equal(t, int64(500000), counters["CgroupCPUUsageUsec"])
+	assert.Equal(t, int64(0), counters["CgroupCPUThrottled"])
+	assert.Equal(t, int64(4096), counters["CgroupIOReadBytes_8_0"])
+	assert.Equal(t, int64(1), counters["CgroupIOReadCount_8_0"])
+}
+
+func TestCgroupCollectorNoFiles(t *testing.T) {
+	_, err := NewCgroupCollector(0, t.TempDir()).Collect(context.Background())
+	assert.ErrorIs(t, err, errNoCgroupFiles)
+}
@@ -0,0 +1,61 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	dto "github.com/Jeskay/musthave_metrics/internal/Dto"
+)
+
+// Collector gathers a group of metrics to be reported by the agent.
+// Gauges returned from Collect replace previous values, counters are treated as increments.
+type Collector interface {
+	// Name returns unique name of the collector used to enable it in configuration.
+	Name() string
+	// Interval returns how often the collector should be run.
+	// Zero interval means that the agent's poll interval is used.
+	Interval() time.Duration
+	// Collect returns current metric values.
+	Collect(ctx context.Context) ([]dto.Metrics, error)
+}
+
+// Registry keeps collectors available to the agent by their names.
+type Registry struct {
+	mu         sync.RWMutex
+	collectors map[string]Collector
+}
+
+// NewRegistry returns empty collector registry.
+func NewRegistry() *Registry {
+	return &Registry{collectors: make(map[string]Collector)}
+}
+
+// Register adds collector to the registry replacing the one with the same name.
+func (r *Registry) Register(c Collector) {
+	r.mu.Lock()
+	r.collectors[c.Name()] = c
+	r.mu.Unlock()
+}
+
+// Get returns collector with specified name.
+func (r *Registry) Get(name string) (Collector, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c, ok := r.collectors[name]
+	return c, ok
+}
+
+// Enabled returns collectors with specified names in the same order.
+func (r *Registry) Enabled(names []string) ([]Collector, error) {
+	enabled := make([]Collector, 0, len(names))
+	for _, name := range names {
+		c, ok := r.Get(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown collector %q", name)
+		}
+		enabled = append(enabled, c)
+	}
+	return enabled, nil
+}
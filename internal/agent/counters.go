@@ -0,0 +1,71 @@
+package agent
+
+import (
+	"sync"
+
+	dto "github.com/Jeskay/musthave_metrics/internal/Dto"
+)
+
+// deltaCounters accumulates counter increments which have not been delivered to the server yet.
+// Deltas are taken out when being sent and restored if the server did not acknowledge them,
+// so every increment is reported exactly once.
+type deltaCounters struct {
+	mu     sync.Mutex
+	values map[string]int64
+}
+
+func newDeltaCounters() *deltaCounters {
+	return &deltaCounters{values: make(map[string]int64)}
+}
+
+// Add increases pending delta of the counter.
+func (c *deltaCounters) Add(name string, delta int64) {
+	c.mu.Lock()
+	c.values[name] += delta
+	c.mu.Unlock()
+}
+
+// Get returns pending delta of the counter.
+func (c *deltaCounters) Get(name string) (dto.Metrics, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.values[name]
+	if !ok {
+		return dto.Metrics{}, false
+	}
+	return dto.NewCounterMetrics(name, v), true
+}
+
+// Names returns names of the counters with pending deltas.
+func (c *deltaCounters) Names() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	names := make([]string, 0, len(c.values))
+	for name := range c.values {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Take returns pending delta of the counter and resets it.
+func (c *deltaCounters) Take(name string) (dto.Metrics, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.values[name]
+	if !ok {
+		return dto.Metrics{}, false
+	}
+	delete(c.values, name)
+	return dto.NewCounterMetrics(name, v), true
+}
+
+// Restore returns undelivered deltas back to pending ones.
+func (c *deltaCounters) Restore(metrics []dto.Metrics) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, m := range metrics {
+		if m.Delta != nil {
+			c.values[m.ID] += *m.Delta
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/cpu"
+
+	dto "github.com/Jeskay/musthave_metrics/internal/Dto"
+)
+
+// CPUCollector reports utilization of every CPU core as CPUutilization1..N gauges
+// and percentage of time spent by all cores in user, system, iowait and idle modes.
+// Values are computed against the previous call, so collection never blocks.
+type CPUCollector struct {
+	interval time.Duration
+	percent  func(interval time.Duration, percpu bool) ([]float64, error)
+	times    func(percpu bool) ([]cpu.TimesStat, error)
+	mu       sync.Mutex
+	prev     *cpu.TimesStat
+}
+
+// NewCPUCollector returns collector of the CPU utilization.
+func NewCPUCollector(interval time.Duration) *CPUCollector {
+	return &CPUCollector{
+		interval: interval,
+		percent:  cpu.Percent,
+		times:    cpu.Times,
+	}
+}
+
+func (c *CPUCollector) Name() string { return "cpu" }
+
+func (c *CPUCollector) Interval() time.Duration { return c.interval }
+
+func (c *CPUCollector) Collect(ctx context.Context) ([]dto.Metrics, error) {
+	percents, err := c.percent(0, true)
+	if err != nil {
+		return nil, err
+	}
+	metrics := make([]dto.Metrics, 0, len(percents)+4)
+	for i, p := range percents {
+		metrics = append(metrics, dto.NewGaugeMetrics(fmt.Sprintf("CPUutilization%d", i+1), p))
+	}
+
+	times, err := c.times(false)
+	if err != nil {
+		return metrics, err
+	}
+	if len(times) == 0 {
+		return metrics, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	prev := c.prev
+	c.prev = &times[0]
+	if prev == nil {
+		return metrics, nil
+	}
+	cur := times[0]
+	total := cpuTotal(cur) - cpuTotal(*prev)
+	if total <= 0 {
+		return metrics, nil
+	}
+	share := func(cur, prev float64) float64 {
+		return (cur - prev) / total * 100
+	}
+	return append(metrics,
+		dto.NewGaugeMetrics("CPUUser", share(cur.User, prev.User)),
+		dto.NewGaugeMetrics("CPUSystem", share(cur.System, prev.System)),
+		dto.NewGaugeMetrics("CPUIowait", share(cur.Iowait, prev.Iowait)),
+		dto.NewGaugeMetrics("CPUIdle", share(cur.Idle, prev.Idle)),
+	), nil
+}
+
+// cpuTotal returns total CPU time. Guest time is already accounted in user time.
+func cpuTotal(t cpu.TimesStat) float64 {
+	return t.User + t.System + t.Idle + t.Nice + t.Iowait + t.Irq + t.Softirq + t.Steal
+}
@@ -0,0 +1,57 @@
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/shirou/gopsutil/cpu"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	dto "github.com/Jeskay/musthave_metrics/internal/Dto"
+)
+
+func TestCPUCollector(t *testing.T) {
+	readings := [][]cpu.TimesStat{
+		{{CPU: "cpu-total", User: 100, System: 50, Idle: 800, Iowait: 50}},
+		{{CPU: "cpu-total", User: 130, System: 60, Idle: 850, Iowait: 60}},
+	}
+	call := 0
+	c := NewCPUCollector(0)
+	c.percent = func(_ time.Duration, percpu bool) ([]float64, error) {
+		assert.True(t, percpu)
+		return []float64{12.5, 50}, nil
+	}
+	c.times = func(percpu bool) ([]cpu.TimesStat, error) {
+		assert.False(t, percpu)
+		r := readings[call]
+		call++
+		return r, nil
+	}
+
+	metrics, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	values := gaugeValues(metrics)
+	assert.Equal(t, map[string]float64{"CPUutilization1": 12.5, "CPUutilization2": 50}, values)
+
+	metrics, err = c.Collect(context.Background())
+	require.NoError(t, err)
+	values = gaugeValues(metrics)
+	assert.Equal(t, 12.5, values["CPUutilization1"])
+	assert.Equal(t, 50.0, values["CPUutilization2"])
+	assert.InDelta(t, 30, values["CPUUser"], 1e-9)
+	assert.InDelta(t, 10, values["CPUSystem"], 1e-9)
+	assert.InDelta(t, 10, values["CPUIowait"], 1e-9)
+	assert.InDelta(t, 50, values["CPUIdle"], 1e-9)
+}
+
+func gaugeValues(metrics []dto.Metrics) map[string]float64 {
+	values := make(map[string]float64, len(metrics))
+	for _, m := range metrics {
+		if m.Value != nil {
+			values[m.ID] = *m.Value
+		}
+	}
+	return values
+}
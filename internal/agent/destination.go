@@ -0,0 +1,187 @@
+package agent
+
+import (
+	"errors"
+	"log/slog"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Jeskay/musthave_metrics/config"
+	dto "github.com/Jeskay/musthave_metrics/internal/Dto"
+	"github.com/Jeskay/musthave_metrics/internal/agent/request"
+	"github.com/Jeskay/musthave_metrics/pkg/retry"
+)
+
+// destination is the metric server with its own keys, circuit breaker and negotiated transport.
+type destination struct {
+	name    string
+	url     string
+	hashKey string
+	cipher  *request.Cipher
+	breaker *circuitBreaker
+	retry   retry.Policy
+	batches batchLimiter
+	// suffix distinguishes agent's own metrics of the destination when there are several ones.
+	suffix       string
+	mu           sync.RWMutex
+	transport    transport
+	capabilities *dto.Capabilities
+	negotiatedAt time.Time
+}
+
+func (d *destination) currentTransport() transport {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.transport
+}
+
+// route delivers every metric once, either to the mirror destination or to the first
+// available destination of the failover group. Routes keep own pending counter deltas
+// and outbox, so the failure of one route does not delay delivery by the others.
+type route struct {
+	name     string
+	mode     string
+	dests    []*destination
+	counters *deltaCounters
+	outbox   *Outbox
+	// suffix distinguishes agent's own metrics of the route when there are several ones.
+	suffix string
+	active atomic.Pointer[destination]
+}
+
+// current returns the destination metrics of the route are delivered to.
+func (rt *route) current() *destination {
+	return rt.active.Load()
+}
+
+// choose selects the first destination of the route which is not known to be down.
+// The last one is chosen when the others are unavailable.
+// Destinations are probed by their circuit breakers when the cool-down passes,
+// so delivery is switched back to the primary destination as soon as it recovers.
+func (rt *route) choose() (d *destination, switched bool) {
+	d = rt.dests[len(rt.dests)-1]
+	for _, candidate := range rt.dests[:len(rt.dests)-1] {
+		if candidate.breaker.Allow() {
+			d = candidate
+			break
+		}
+	}
+	return d, rt.active.Swap(d) != d
+}
+
+// setupDestinations creates destinations of the agent and groups them into routes.
+// Failover destinations form a single route placed at the position of the first one.
+func (svc *AgentService) setupDestinations() {
+	confs := svc.config.GetDestinations()
+	var failover *route
+	for _, c := range confs {
+		d := svc.newDestination(c, len(confs) > 1)
+		svc.destinations = append(svc.destinations, d)
+		switch c.Mode {
+		case config.DestinationMirror:
+			svc.routes = append(svc.routes, &route{name: d.name, mode: c.Mode, dests: []*destination{d}})
+			continue
+		case config.DestinationFailover:
+		default:
+			svc.logger.Error("unknown destination mode, failover is used",
+				slog.String("destination", d.name),
+				slog.String("mode", c.Mode),
+			)
+		}
+		if failover == nil {
+			failover = &route{name: config.DestinationFailover, mode: config.DestinationFailover}
+			svc.routes = append(svc.routes, failover)
+		}
+		failover.dests = append(failover.dests, d)
+	}
+	for _, rt := range svc.routes {
+		rt.active.Store(rt.dests[0])
+		dir := svc.config.OutboxDir
+		if len(svc.routes) == 1 {
+			// Counters are delivered by the only route without being distributed.
+			rt.counters = svc.counters
+		} else {
+			rt.counters = newDeltaCounters()
+			rt.suffix = "_" + metricSuffix(rt.name)
+			dir = filepath.Join(dir, metricSuffix(rt.name))
+		}
+		if svc.config.OutboxDir == "" {
+			continue
+		}
+		var err error
+		if rt.outbox, err = NewOutbox(dir, svc.config.GetOutboxMaxSize(), svc.config.GetOutboxMaxAge()); err != nil {
+			svc.logger.Error("failed to open outbox", slog.String("route", rt.name), slog.String("error", err.Error()))
+		}
+	}
+}
+
+func (svc *AgentService) newDestination(conf config.Destination, several bool) *destination {
+	d := &destination{
+		name:      conf.Name,
+		url:       svc.config.DestinationURL(conf.Address),
+		hashKey:   conf.HashKey,
+		transport: legacyTransport,
+	}
+	if several {
+		d.suffix = "_" + metricSuffix(d.name)
+	}
+	if conf.PublicKey != "" {
+		cipher, err := request.NewCipher(conf.PublicKey)
+		if err != nil {
+			svc.logger.Error("failed to initialize cipher service", slog.String("destination", d.name))
+		}
+		d.cipher = cipher
+	}
+	d.retry = retry.Default
+	d.retry.Retryable = func(err error) bool {
+		return d.breaker.State() == breakerClosed && retry.IsRetryableHTTP(err)
+	}
+	d.retry.Budget = retry.NewBudget(10, 0.1)
+	d.retry.OnRetry = func(attempt int, err error, delay time.Duration) {
+		svc.logger.Warn("retrying request",
+			slog.String("destination", d.name),
+			slog.Int("attempt", attempt),
+			slog.Duration("delay", delay),
+			slog.String("error", err.Error()),
+		)
+		svc.counters.Add(retriesMetric+d.suffix, 1)
+	}
+	d.breaker = newCircuitBreaker(
+		svc.config.GetBreakerThreshold(),
+		svc.config.GetBreakerCooldown(),
+		func() error { return svc.ping(d) },
+		func(from, to breakerState) { svc.breakerChanged(d, from, to) },
+	)
+	return d
+}
+
+// distributeCounters moves pending counter deltas to every route when there are several ones.
+func (svc *AgentService) distributeCounters() {
+	if len(svc.routes) < 2 {
+		return
+	}
+	for _, name := range svc.counters.Names() {
+		m, ok := svc.counters.Take(name)
+		if !ok {
+			continue
+		}
+		for _, rt := range svc.routes {
+			rt.counters.Restore([]dto.Metrics{m})
+		}
+	}
+}
+
+// CheckAPIAvailability of the metric servers and returns error if none of them is accessible.
+func (svc *AgentService) CheckAPIAvailability() error {
+	errs := make([]error, 0, len(svc.destinations))
+	for _, d := range svc.destinations {
+		err := svc.ping(d)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
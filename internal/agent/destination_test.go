@@ -0,0 +1,209 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Jeskay/musthave_metrics/config"
+	"github.com/Jeskay/musthave_metrics/internal"
+)
+
+// testServer counts PollCount deltas received in batches signed with its key and fails while down is set.
+type testServer struct {
+	*httptest.Server
+	down     atomic.Bool
+	received atomic.Int64
+}
+
+func newTestServer(t *testing.T, key string) *testServer {
+	s := &testServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.down.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if r.URL.Path != "/updates" {
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if !assert.NoError(t, err) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		sum := sha256.Sum256(append(body, key...))
+		if r.Header.Get(internal.HashHeader) != hex.EncodeToString(sum[:]) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		metrics, ok := decodeMetrics(t, r)
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		for _, m := range metrics {
+			if m.ID == "PollCount" {
+				s.received.Add(*m.Delta)
+			}
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func sendCycle(svc *AgentService, delta int64) {
+	svc.counters.Add("PollCount", delta)
+	svc.distributeCounters()
+	for _, rt := range svc.routes {
+		svc.sendRoute(context.Background(), rt)
+	}
+}
+
+func newDestinationsService(t *testing.T, conf *config.AgentConfig) *AgentService {
+	svc := NewAgentService(http.DefaultClient, conf, slog.NewTextHandler(os.Stdout, nil))
+	for _, d := range svc.destinations {
+		d.negotiatedAt = time.Now()
+	}
+	return svc
+}
+
+func TestMirrorDestinations(t *testing.T) {
+	first, second := newTestServer(t, "first-key"), newTestServer(t, "second-key")
+	conf := &config.AgentConfig{
+		RateLimit: 1,
+		Destinations: []config.Destination{
+			{Name: "first", Address: first.URL, Mode: config.DestinationMirror, HashKey: "first-key"},
+			{Name: "second", Address: second.URL, Mode: config.DestinationMirror, HashKey: "second-key"},
+		},
+	}
+	svc := newDestinationsService(t, conf)
+	require.Len(t, svc.routes, 2)
+
+	second.down.Store(true)
+	sendCycle(svc, 3)
+	assert.Equal(t, int64(3), first.received.Load())
+	m, ok := svc.routes[1].counters.Get("PollCount")
+	require.True(t, ok)
+	assert.Equal(t, int64(3), *m.Delta, "undelivered deltas are kept by the failed route")
+	_, ok = svc.routes[0].counters.Get("PollCount")
+	assert.False(t, ok)
+
+	second.down.Store(false)
+	sendCycle(svc, 1)
+	assert.Equal(t, int64(4), first.received.Load())
+	assert.Equal(t, int64(4), second.received.Load())
+	_, ok = svc.storage.Get(breakerStateMetric + "_second")
+	assert.True(t, ok, "own metrics are reported per destination")
+}
+
+func TestFailoverDestinations(t *testing.T) {
+	primary, secondary := newTestServer(t, ""), newTestServer(t, "")
+	conf := &config.AgentConfig{
+		RateLimit:        1,
+		BreakerThreshold: 1,
+		BreakerCooldown:  60,
+		Destinations: []config.Destination{
+			{Name: "primary", Address: primary.URL},
+			{Name: "secondary", Address: secondary.URL, Mode: config.DestinationFailover},
+		},
+	}
+	svc := newDestinationsService(t, conf)
+	require.Len(t, svc.routes, 1)
+	rt := svc.routes[0]
+
+	primary.down.Store(true)
+	sendCycle(svc, 1)
+	assert.Equal(t, breakerOpen, svc.destinations[0].breaker.State())
+	assert.Equal(t, "primary", rt.current().name)
+
+	sendCycle(svc, 2)
+	assert.Equal(t, "secondary", rt.current().name)
+	assert.Equal(t, int64(3), secondary.received.Load(), "deltas undelivered to primary are sent to secondary")
+
+	primary.down.Store(false)
+	svc.destinations[0].breaker.now = func() time.Time { return time.Now().Add(time.Minute) }
+	sendCycle(svc, 4)
+	assert.Equal(t, "primary", rt.current().name, "delivery is switched back when primary recovers")
+	assert.Equal(t, int64(4), primary.received.Load())
+	assert.Equal(t, int64(3), secondary.received.Load())
+}
+
+func TestStalledMirrorDestination(t *testing.T) {
+	var reports atomic.Int64
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/updates" {
+			reports.Add(1)
+		}
+	}))
+	defer healthy.Close()
+	release := make(chan struct{})
+	arrived := make(chan struct{}, 1)
+	stalled := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/updates" {
+			return
+		}
+		select {
+		case arrived <- struct{}{}:
+		default:
+		}
+		<-release
+	}))
+	defer stalled.Close()
+	unblock := sync.OnceFunc(func() { close(release) })
+	defer unblock()
+
+	conf := &config.AgentConfig{
+		RateLimit:      2,
+		PollInterval:   2,
+		ReportInterval: 10,
+		Collectors:     []string{"counting"},
+		Destinations: []config.Destination{
+			{Name: "healthy", Address: healthy.URL, Mode: config.DestinationMirror},
+			{Name: "stalled", Address: stalled.URL, Mode: config.DestinationMirror},
+		},
+	}
+	svc := newDestinationsService(t, conf)
+	clock := newFakeClock()
+	svc.clock = clock
+	svc.Registry().Register(&countingCollector{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- svc.Run(ctx)
+	}()
+	require.Eventually(t, func() bool { return clock.Tickers() == 2 }, time.Second, time.Millisecond)
+
+	clock.Advance(10 * time.Second)
+	<-arrived
+	require.Eventually(t, func() bool { return reports.Load() == 1 }, time.Second, time.Millisecond)
+	for i := int64(2); i <= 3; i++ {
+		clock.Advance(10 * time.Second)
+		require.Eventually(t, func() bool { return reports.Load() == i }, time.Second, time.Millisecond,
+			"healthy mirror gets reports while the other one is stalled")
+	}
+
+	unblock()
+	cancel()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("agent did not stop")
+	}
+}
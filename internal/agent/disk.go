@@ -0,0 +1,92 @@
+package agent
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/disk"
+
+	dto "github.com/Jeskay/musthave_metrics/internal/Dto"
+)
+
+// DiskCollector reports usage of every mounted partition as gauges and disk IO statistics as counters.
+// Partitions are filtered by device and mount point, so either of them can be used in the patterns,
+// IO statistics by device name.
+type DiskCollector struct {
+	interval   time.Duration
+	filter     *nameFilter
+	partitions func(ctx context.Context, all bool) ([]disk.PartitionStat, error)
+	usage      func(ctx context.Context, path string) (*disk.UsageStat, error)
+	ioCounters func(ctx context.Context, names ...string) (map[string]disk.IOCountersStat, error)
+	mu         sync.Mutex
+	io         *cumulativeCounters
+}
+
+// NewDiskCollector returns collector of the disk usage with device include and exclude patterns.
+func NewDiskCollector(interval time.Duration, include, exclude []string) (*DiskCollector, error) {
+	filter, err := newNameFilter(include, exclude)
+	if err != nil {
+		return nil, err
+	}
+	return &DiskCollector{
+		interval:   interval,
+		filter:     filter,
+		partitions: disk.PartitionsWithContext,
+		usage:      disk.UsageWithContext,
+		ioCounters: disk.IOCountersWithContext,
+		io:         newCumulativeCounters(),
+	}, nil
+}
+
+func (c *DiskCollector) Name() string { return "disk" }
+
+func (c *DiskCollector) Interval() time.Duration { return c.interval }
+
+func (c *DiskCollector) Collect(ctx context.Context) ([]dto.Metrics, error) {
+	partitions, err := c.partitions(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	metrics := make([]dto.Metrics, 0)
+	for _, p := range partitions {
+		if !c.filter.Match(p.Device, p.Mountpoint) {
+			continue
+		}
+		usage, err := c.usage(ctx, p.Mountpoint)
+		if err != nil {
+			continue
+		}
+		suffix := "_" + metricSuffix(p.Mountpoint)
+		metrics = append(metrics,
+			dto.NewGaugeMetrics("DiskTotal"+suffix, float64(usage.Total)),
+			dto.NewGaugeMetrics("DiskFree"+suffix, float64(usage.Free)),
+			dto.NewGaugeMetrics("DiskUsed"+suffix, float64(usage.Used)),
+			dto.NewGaugeMetrics("DiskUsedPercent"+suffix, usage.UsedPercent),
+		)
+	}
+
+	counters, err := c.ioCounters(ctx)
+	if err != nil {
+		return metrics, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, io := range counters {
+		if !c.filter.Match(name) {
+			continue
+		}
+		suffix := "_" + metricSuffix(name)
+		for metric, value := range map[string]uint64{
+			"DiskReadBytes" + suffix:  io.ReadBytes,
+			"DiskWriteBytes" + suffix: io.WriteBytes,
+			"DiskReadCount" + suffix:  io.ReadCount,
+			"DiskWriteCount" + suffix: io.WriteCount,
+		} {
+			if delta, ok := c.io.Delta(metric, value); ok {
+				metrics = append(metrics, dto.NewCounterMetrics(metric, delta))
+			}
+		}
+	}
+	return metrics, nil
+}
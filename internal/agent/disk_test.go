@@ -0,0 +1,65 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/shirou/gopsutil/disk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiskCollector(t *testing.T) {
+	c, err := NewDiskCollector(0, nil, []string{"loop"})
+	require.NoError(t, err)
+	c.partitions = func(_ context.Context, all bool) ([]disk.PartitionStat, error) {
+		assert.False(t, all)
+		return []disk.PartitionStat{
+			{Device: "/dev/sda1", Mountpoint: "/"},
+			{Device: "/dev/sdc1", Mountpoint: "/root"},
+			{Device: "/dev/loop0", Mountpoint: "/snap/core"},
+			{Device: "/dev/sdb1", Mountpoint: "/broken"},
+		}, nil
+	}
+	c.usage = func(_ context.Context, path string) (*disk.UsageStat, error) {
+		if path == "/broken" {
+			return nil, errors.New("permission denied")
+		}
+		if path == "/root" {
+			return &disk.UsageStat{Total: 50, Free: 45, Used: 5, UsedPercent: 10}, nil
+		}
+		return &disk.UsageStat{Total: 100, Free: 40, Used: 60, UsedPercent: 60}, nil
+	}
+	reads := uint64(1000)
+	c.ioCounters = func(context.Context, ...string) (map[string]disk.IOCountersStat, error) {
+		reads += 500
+		return map[string]disk.IOCountersStat{
+			"sda":   {ReadBytes: reads, WriteBytes: 10, ReadCount: 1, WriteCount: 1},
+			"loop0": {ReadBytes: reads},
+		}, nil
+	}
+
+	metrics, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{
+		"DiskTotal_rootfs":       100,
+		"DiskFree_rootfs":        40,
+		"DiskUsed_rootfs":        60,
+		"DiskUsedPercent_rootfs": 60,
+		"DiskTotal_root":         50,
+		"DiskFree_root":          45,
+		"DiskUsed_root":          5,
+		"DiskUsedPercent_root":   10,
+	}, gaugeValues(metrics), "partition is excluded by device")
+	assert.Empty(t, counterValues(metrics), "first reading of IO counters is only remembered")
+
+	metrics, err = c.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{
+		"DiskReadBytes_sda":  500,
+		"DiskWriteBytes_sda": 0,
+		"DiskReadCount_sda":  0,
+		"DiskWriteCount_sda": 0,
+	}, counterValues(metrics))
+}
@@ -0,0 +1,130 @@
+package agent
+
+import (
+	"math"
+	"regexp"
+	"strings"
+)
+
+// nameFilter matches names against include and exclude regular expressions.
+// Names of an object pass the filter if any of them matches any include pattern or the include list is empty,
+// and none of them matches any of exclude patterns.
+type nameFilter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+func newNameFilter(include, exclude []string) (*nameFilter, error) {
+	f := &nameFilter{}
+	var err error
+	if f.include, err = compilePatterns(include); err != nil {
+		return nil, err
+	}
+	if f.exclude, err = compilePatterns(exclude); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Match reports whether the object known by the names passes the filter.
+func (f *nameFilter) Match(names ...string) bool {
+	if matchAny(f.exclude, names) {
+		return false
+	}
+	return len(f.include) == 0 || matchAny(f.include, names)
+}
+
+func matchAny(patterns []*regexp.Regexp, names []string) bool {
+	for _, re := range patterns {
+		for _, name := range names {
+			if re.MatchString(name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		if p == "" {
+			continue
+		}
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// isFinite reports whether the value can be sent as metric value.
+func isFinite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+var metricNameReplacer = regexp.MustCompile(`[^A-Za-z0-9_]+`)
+
+// metricSuffix converts device or mount point name into metric name suffix.
+// The root mount point gets "rootfs" suffix, so it is not mixed up with "/root".
+func metricSuffix(name string) string {
+	name = strings.Trim(metricNameReplacer.ReplaceAllString(name, "_"), "_")
+	if name == "" {
+		return "rootfs"
+	}
+	return name
+}
+
+// cumulativeCounters converts monotonically increasing values into deltas since the previous reading.
+// The first reading of a value is only remembered. Decreased value is treated as counter reset.
+type cumulativeCounters struct {
+	prev map[string]uint64
+}
+
+func newCumulativeCounters() *cumulativeCounters {
+	return &cumulativeCounters{prev: make(map[string]uint64)}
+}
+
+func (c *cumulativeCounters) Delta(name string, value uint64) (int64, bool) {
+	prev, ok := c.prev[name]
+	c.prev[name] = value
+	if !ok {
+		return 0, false
+	}
+	if value < prev {
+		return int64(value), true
+	}
+	return int64(value - prev), true
+}
+
+// fractionalCounters converts monotonically increasing fractional values into integer deltas.
+// Fractional part of the increase is carried over to the next reading, so small increments are not lost.
+type fractionalCounters struct {
+	prev      map[string]float64
+	remainder map[string]float64
+}
+
+func newFractionalCounters() *fractionalCounters {
+	return &fractionalCounters{
+		prev:      make(map[string]float64),
+		remainder: make(map[string]float64),
+	}
+}
+
+func (c *fractionalCounters) Delta(name string, value float64) (int64, bool) {
+	prev, ok := c.prev[name]
+	c.prev[name] = value
+	if !ok {
+		return 0, false
+	}
+	increase := value - prev
+	if value < prev {
+		increase = value
+	}
+	total := c.remainder[name] + increase
+	whole := math.Floor(total)
+	c.remainder[name] = total - whole
+	return int64(whole), true
+}
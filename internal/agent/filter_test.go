@@ -0,0 +1,87 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNameFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		names   []string
+		want    bool
+	}{
+		{"empty filter", nil, nil, []string{"eth0"}, true},
+		{"included", []string{"^eth"}, nil, []string{"eth0"}, true},
+		{"not included", []string{"^eth"}, nil, []string{"lo"}, false},
+		{"excluded", nil, []string{"^lo$"}, []string{"lo"}, false},
+		{"exclude wins", []string{"^eth"}, []string{"eth1"}, []string{"eth1"}, false},
+		{"empty patterns are ignored", []string{""}, []string{""}, []string{"eth0"}, true},
+		{"excluded by device", nil, []string{"loop"}, []string{"/dev/loop0", "/snap/core"}, false},
+		{"excluded by mount point", nil, []string{"^/snap"}, []string{"/dev/loop0", "/snap/core"}, false},
+		{"included by mount point", []string{"^/$"}, nil, []string{"/dev/sda1", "/"}, true},
+		{"included by device but excluded by mount point", []string{"sda"}, []string{"^/boot"}, []string{"/dev/sda1", "/boot"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := newNameFilter(tt.include, tt.exclude)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, f.Match(tt.names...))
+		})
+	}
+
+	_, err := newNameFilter([]string{"("}, nil)
+	assert.Error(t, err)
+}
+
+func TestMetricSuffix(t *testing.T) {
+	assert.Equal(t, "rootfs", metricSuffix("/"))
+	assert.Equal(t, "root", metricSuffix("/root"))
+	assert.Equal(t, "var_lib_docker", metricSuffix("/var/lib/docker"))
+	assert.Equal(t, "dev_sda1", metricSuffix("/dev/sda1"))
+}
+
+func TestCumulativeCounters(t *testing.T) {
+	c := newCumulativeCounters()
+	_, ok := c.Delta("bytes", 100)
+	assert.False(t, ok, "first reading is only remembered")
+
+	delta, ok := c.Delta("bytes", 150)
+	assert.True(t, ok)
+	assert.Equal(t, int64(50), delta)
+
+	delta, ok = c.Delta("bytes", 150)
+	assert.True(t, ok)
+	assert.Zero(t, delta)
+
+	delta, ok = c.Delta("bytes", 20)
+	assert.True(t, ok)
+	assert.Equal(t, int64(20), delta, "decreased value is treated as reset")
+
+	_, ok = c.Delta("packets", 5)
+	assert.False(t, ok)
+}
+
+func TestFractionalCounters(t *testing.T) {
+	c := newFractionalCounters()
+	_, ok := c.Delta("seconds", 10.25)
+	assert.False(t, ok, "first reading is only remembered")
+
+	var total int64
+	for _, v := range []float64{10.5, 10.75, 11, 11.25, 12.5} {
+		delta, ok := c.Delta("seconds", v)
+		require.True(t, ok)
+		total += delta
+	}
+	assert.Equal(t, int64(2), total, "increments below one are accumulated")
+
+	delta, ok := c.Delta("seconds", 0.5)
+	assert.True(t, ok)
+	assert.Equal(t, int64(0), delta, "decreased value is treated as reset")
+	delta, _ = c.Delta("seconds", 1.25)
+	assert.Equal(t, int64(1), delta, "remainder is carried over the reset")
+}
@@ -0,0 +1,37 @@
+package agent
+
+import (
+	"context"
+	"time"
+
+	"github.com/shirou/gopsutil/load"
+
+	dto "github.com/Jeskay/musthave_metrics/internal/Dto"
+)
+
+// LoadCollector reports 1, 5 and 15 minute load averages of the host.
+type LoadCollector struct {
+	interval time.Duration
+	avg      func(ctx context.Context) (*load.AvgStat, error)
+}
+
+// NewLoadCollector returns collector of the load averages.
+func NewLoadCollector(interval time.Duration) *LoadCollector {
+	return &LoadCollector{interval: interval, avg: load.AvgWithContext}
+}
+
+func (c *LoadCollector) Name() string { return "load" }
+
+func (c *LoadCollector) Interval() time.Duration { return c.interval }
+
+func (c *LoadCollector) Collect(ctx context.Context) ([]dto.Metrics, error) {
+	avg, err := c.avg(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return []dto.Metrics{
+		dto.NewGaugeMetrics("Load1", avg.Load1),
+		dto.NewGaugeMetrics("Load5", avg.Load5),
+		dto.NewGaugeMetrics("Load15", avg.Load15),
+	}, nil
+}
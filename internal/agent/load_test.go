@@ -0,0 +1,27 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/shirou/gopsutil/load"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadCollector(t *testing.T) {
+	c := NewLoadCollector(0)
+	c.avg = func(context.Context) (*load.AvgStat, error) {
+		return &load.AvgStat{Load1: 0.5, Load5: 1.5, Load15: 2.5}, nil
+	}
+	metrics, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{"Load1": 0.5, "Load5": 1.5, "Load15": 2.5}, gaugeValues(metrics))
+
+	c.avg = func(context.Context) (*load.AvgStat, error) {
+		return nil, errors.New("not supported")
+	}
+	_, err = c.Collect(context.Background())
+	assert.Error(t, err)
+}
@@ -0,0 +1,287 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Jeskay/musthave_metrics/config"
+	dto "github.com/Jeskay/musthave_metrics/internal/Dto"
+)
+
+const (
+	logReadChunk       = 64 * 1024
+	logFingerprintSize = 256
+)
+
+// logTailState is persisted position of the tailed file.
+// Fingerprint is checksum of the first bytes of the file which tells whether
+// the file at the path is still the same one after restart.
+type logTailState struct {
+	Offset         int64  `json:"offset"`
+	Fingerprint    uint32 `json:"fingerprint"`
+	FingerprintLen int64  `json:"fingerprint_len"`
+}
+
+type logRule struct {
+	re      *regexp.Regexp
+	counter string
+	gauges  bool
+}
+
+type tailedFile struct {
+	path   string
+	rules  []logRule
+	file   *os.File
+	offset int64
+	// missing is set when the file did not exist, so it is read from the start once created.
+	missing bool
+}
+
+// LogTailCollector tails log files and counts lines matching the configured patterns.
+// Values of the named capture groups can be reported as gauges. Files are followed
+// across rotation and truncation, read offsets are kept in the state file if it is set.
+// Files without saved offset are read from the end unless they are created after the agent start.
+type LogTailCollector struct {
+	interval  time.Duration
+	statePath string
+	mu        sync.Mutex
+	files     []*tailedFile
+	state     map[string]logTailState
+}
+
+// NewLogTailCollector returns collector of the specified log files.
+func NewLogTailCollector(interval time.Duration, targets []config.LogTailTarget, statePath string) (*LogTailCollector, error) {
+	c := &LogTailCollector{
+		interval:  interval,
+		statePath: statePath,
+		state:     make(map[string]logTailState),
+	}
+	for _, t := range targets {
+		if t.Path == "" {
+			return nil, errors.New("log tail target must have path")
+		}
+		file := &tailedFile{path: t.Path}
+		for _, r := range t.Rules {
+			if r.Counter == "" {
+				return nil, fmt.Errorf("log rule %q must have counter name", r.Pattern)
+			}
+			re, err := regexp.Compile(r.Pattern)
+			if err != nil {
+				return nil, err
+			}
+			file.rules = append(file.rules, logRule{re: re, counter: r.Counter, gauges: r.Gauges})
+		}
+		c.files = append(c.files, file)
+	}
+	if statePath != "" {
+		data, err := os.ReadFile(statePath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &c.state); err != nil {
+				return nil, fmt.Errorf("invalid log tail state: %w", err)
+			}
+		}
+	}
+	return c, nil
+}
+
+func (c *LogTailCollector) Name() string { return "logtail" }
+
+func (c *LogTailCollector) Interval() time.Duration { return c.interval }
+
+func (c *LogTailCollector) Collect(ctx context.Context) ([]dto.Metrics, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counts := make(map[string]int64)
+	gauges := make(map[string]float64)
+	var errs []error
+	for _, f := range c.files {
+		handle := func(line []byte) {
+			for _, r := range f.rules {
+				match := r.re.FindSubmatch(line)
+				if match == nil {
+					continue
+				}
+				counts[r.counter]++
+				if !r.gauges {
+					continue
+				}
+				for i, name := range r.re.SubexpNames() {
+					if name == "" || match[i] == nil {
+						continue
+					}
+					if v, err := strconv.ParseFloat(string(match[i]), 64); err == nil && isFinite(v) {
+						gauges[name] = v
+					}
+				}
+			}
+		}
+		if err := c.tail(f, handle); err != nil {
+			errs = append(errs, fmt.Errorf("tail %s: %w", f.path, err))
+		}
+	}
+	if err := c.saveState(); err != nil {
+		errs = append(errs, err)
+	}
+	metrics := make([]dto.Metrics, 0, len(counts)+len(gauges))
+	for name, count := range counts {
+		metrics = append(metrics, dto.NewCounterMetrics(name, count))
+	}
+	for name, value := range gauges {
+		metrics = append(metrics, dto.NewGaugeMetrics(name, value))
+	}
+	return metrics, errors.Join(errs...)
+}
+
+// tail reads new lines of the file. The rest of the rotated file is read
+// before switching to the new file at the same path.
+func (c *LogTailCollector) tail(f *tailedFile, handle func([]byte)) error {
+	if f.file == nil {
+		err := c.open(f)
+		if errors.Is(err, os.ErrNotExist) {
+			f.missing = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	info, err := f.file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() < f.offset {
+		f.offset = 0
+	}
+	if err := f.read(handle, false); err != nil {
+		return err
+	}
+	current, err := os.Stat(f.path)
+	if err != nil || os.SameFile(info, current) {
+		return nil
+	}
+	if err := f.read(handle, true); err != nil {
+		return err
+	}
+	f.file.Close()
+	file, err := os.Open(f.path)
+	if err != nil {
+		f.file = nil
+		return err
+	}
+	f.file, f.offset = file, 0
+	return f.read(handle, false)
+}
+
+// open opens the file and restores saved offset if the file has not been replaced since.
+func (c *LogTailCollector) open(f *tailedFile) error {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	state, ok := c.state[f.path]
+	if !ok {
+		f.offset = info.Size()
+		if f.missing {
+			f.offset = 0
+		}
+		return nil
+	}
+	f.offset = 0
+	if state.Offset <= info.Size() {
+		if sum, n, err := fingerprint(file, state.FingerprintLen); err == nil && n == state.FingerprintLen && sum == state.Fingerprint {
+			f.offset = state.Offset
+		}
+	}
+	return nil
+}
+
+// read passes complete lines after the current offset to the handler.
+// Unterminated last line is left for the next read unless final is set.
+func (f *tailedFile) read(handle func([]byte), final bool) error {
+	buf := make([]byte, logReadChunk)
+	for {
+		n, err := f.file.ReadAt(buf, f.offset)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+		chunk := buf[:n]
+		end := bytes.LastIndexByte(chunk, '\n') + 1
+		if (end == 0 && n == len(buf)) || (final && n < len(buf)) {
+			end = n
+		}
+		if end == 0 {
+			return nil
+		}
+		for _, line := range bytes.Split(bytes.TrimSuffix(chunk[:end], []byte{'\n'}), []byte{'\n'}) {
+			handle(bytes.TrimSuffix(line, []byte{'\r'}))
+		}
+		f.offset += int64(end)
+	}
+}
+
+func (c *LogTailCollector) saveState() error {
+	if c.statePath == "" {
+		return nil
+	}
+	for _, f := range c.files {
+		if f.file == nil {
+			continue
+		}
+		sum, n, err := fingerprint(f.file, logFingerprintSize)
+		if err != nil {
+			return err
+		}
+		c.state[f.path] = logTailState{Offset: f.offset, Fingerprint: sum, FingerprintLen: n}
+	}
+	data, err := json.Marshal(c.state)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.statePath), filepath.Base(c.statePath)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), c.statePath)
+}
+
+// fingerprint returns checksum of up to size first bytes of the file and the number of bytes read.
+func fingerprint(file *os.File, size int64) (uint32, int64, error) {
+	buf := make([]byte, size)
+	n, err := file.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return 0, 0, err
+	}
+	return crc32.ChecksumIEEE(buf[:n]), int64(n), nil
+}
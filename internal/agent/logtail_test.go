@@ -0,0 +1,107 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Jeskay/musthave_metrics/config"
+)
+
+func appendFile(t *testing.T, path, data string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	require.NoError(t, err)
+	_, err = f.WriteString(data)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+}
+
+func TestLogTailCollector(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	statePath := filepath.Join(dir, "state.json")
+	targets := []config.LogTailTarget{{
+		Path: path,
+		Rules: []config.LogRule{
+			{Pattern: `level=error`, Counter: "AppErrors"},
+			{Pattern: `latency=(?P<Latency>[0-9.]+)`, Counter: "AppRequests", Gauges: true},
+		},
+	}}
+	appendFile(t, path, "level=error old entry\n")
+
+	c, err := NewLogTailCollector(0, targets, statePath)
+	require.NoError(t, err)
+	collect := func() ([]int64, map[string]float64) {
+		metrics, err := c.Collect(context.Background())
+		require.NoError(t, err)
+		counters := counterValues(metrics)
+		return []int64{counters["AppErrors"], counters["AppRequests"]}, gaugeValues(metrics)
+	}
+
+	metrics, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, metrics, "existing lines are skipped on the first start")
+
+	appendFile(t, path, "level=error first\nlatency=0.5\nlatency=1.5\nlevel=error partial")
+	counts, gauges := collect()
+	assert.Equal(t, []int64{1, 2}, counts)
+	assert.Equal(t, map[string]float64{"Latency": 1.5}, gauges)
+
+	appendFile(t, path, " line\n")
+	require.NoError(t, os.Rename(path, path+".1"))
+	appendFile(t, path+".1", "level=error written before reopen\n")
+	appendFile(t, path, "level=error rotated\n")
+	counts, _ = collect()
+	assert.Equal(t, []int64{3, 0}, counts, "rest of the rotated file and the new file are read")
+
+	require.NoError(t, os.Truncate(path, 0))
+	appendFile(t, path, "latency=2\n")
+	counts, gauges = collect()
+	assert.Equal(t, []int64{0, 1}, counts)
+	assert.Equal(t, map[string]float64{"Latency": 2}, gauges)
+
+	appendFile(t, path, "level=error while stopped\n")
+	c, err = NewLogTailCollector(0, targets, statePath)
+	require.NoError(t, err)
+	counts, _ = collect()
+	assert.Equal(t, []int64{1, 0}, counts, "reading continues from the saved offset")
+}
+
+func TestLogTailCollectorMissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	c, err := NewLogTailCollector(0, []config.LogTailTarget{{
+		Path:  path,
+		Rules: []config.LogRule{{Pattern: `ERROR`, Counter: "AppErrors"}},
+	}}, "")
+	require.NoError(t, err)
+
+	_, err = c.Collect(context.Background())
+	require.NoError(t, err)
+	appendFile(t, path, "ERROR first line of the new file\n")
+	metrics, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"AppErrors": 1}, counterValues(metrics))
+}
+
+func TestLogTailCollectorNonFinite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	appendFile(t, path, "")
+	c, err := NewLogTailCollector(0, []config.LogTailTarget{{
+		Path:  path,
+		Rules: []config.LogRule{{Pattern: `value=(?P<Value>\S+)`, Counter: "AppValues", Gauges: true}},
+	}}, "")
+	require.NoError(t, err)
+	_, err = c.Collect(context.Background())
+	require.NoError(t, err)
+
+	appendFile(t, path, "value=4\nvalue=NaN\nvalue=+Inf\n")
+	metrics, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"AppValues": 3}, counterValues(metrics))
+	assert.Equal(t, map[string]float64{"Value": 4}, gaugeValues(metrics), "NaN and infinite values are skipped")
+}
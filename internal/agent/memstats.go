@@ -0,0 +1,96 @@
+package agent
+
+import (
+	"context"
+	"math/rand/v2"
+	"runtime"
+	"time"
+
+	"github.com/shirou/gopsutil/mem"
+
+	dto "github.com/Jeskay/musthave_metrics/internal/Dto"
+)
+
+// MemStatsCollector reports runtime.MemStats fields along with poll count and random value.
+type MemStatsCollector struct {
+	interval time.Duration
+	read     func(*runtime.MemStats)
+}
+
+// NewMemStatsCollector returns collector of the Go runtime memory statistics.
+func NewMemStatsCollector(interval time.Duration) *MemStatsCollector {
+	return &MemStatsCollector{
+		interval: interval,
+		read:     runtime.ReadMemStats,
+	}
+}
+
+func (c *MemStatsCollector) Name() string { return "memstats" }
+
+func (c *MemStatsCollector) Interval() time.Duration { return c.interval }
+
+func (c *MemStatsCollector) Collect(ctx context.Context) ([]dto.Metrics, error) {
+	mStats := &runtime.MemStats{}
+	c.read(mStats)
+	rValue := 1e-307 + rand.Float64()*(1e+308-1e-307)
+	return []dto.Metrics{
+		dto.NewGaugeMetrics("Alloc", float64(mStats.Alloc)),
+		dto.NewGaugeMetrics("BuckHashSys", float64(mStats.BuckHashSys)),
+		dto.NewGaugeMetrics("Frees", float64(mStats.Frees)),
+		dto.NewGaugeMetrics("GCCPUFraction", float64(mStats.GCCPUFraction)),
+		dto.NewGaugeMetrics("GCSys", float64(mStats.GCSys)),
+		dto.NewGaugeMetrics("HeapAlloc", float64(mStats.HeapAlloc)),
+		dto.NewGaugeMetrics("HeapIdle", float64(mStats.HeapIdle)),
+		dto.NewGaugeMetrics("HeapInuse", float64(mStats.HeapInuse)),
+		dto.NewGaugeMetrics("HeapObjects", float64(mStats.HeapObjects)),
+		dto.NewGaugeMetrics("HeapReleased", float64(mStats.HeapReleased)),
+		dto.NewGaugeMetrics("HeapSys", float64(mStats.HeapSys)),
+		dto.NewGaugeMetrics("LastGC", float64(mStats.LastGC)),
+		dto.NewGaugeMetrics("Lookups", float64(mStats.Lookups)),
+		dto.NewGaugeMetrics("MCacheInuse", float64(mStats.MCacheInuse)),
+		dto.NewGaugeMetrics("MCacheSys", float64(mStats.MCacheSys)),
+		dto.NewGaugeMetrics("MSpanInuse", float64(mStats.MSpanInuse)),
+		dto.NewGaugeMetrics("MSpanSys", float64(mStats.MSpanSys)),
+		dto.NewGaugeMetrics("Mallocs", float64(mStats.Mallocs)),
+		dto.NewGaugeMetrics("NextGC", float64(mStats.NextGC)),
+		dto.NewGaugeMetrics("NumForcedGC", float64(mStats.NumForcedGC)),
+		dto.NewGaugeMetrics("NumGC", float64(mStats.NumGC)),
+		dto.NewGaugeMetrics("OtherSys", float64(mStats.OtherSys)),
+		dto.NewGaugeMetrics("PauseTotalNs", float64(mStats.PauseTotalNs)),
+		dto.NewGaugeMetrics("StackInuse", float64(mStats.StackInuse)),
+		dto.NewGaugeMetrics("StackSys", float64(mStats.StackSys)),
+		dto.NewGaugeMetrics("Sys", float64(mStats.Sys)),
+		dto.NewGaugeMetrics("TotalAlloc", float64(mStats.TotalAlloc)),
+		dto.NewCounterMetrics("PollCount", 1),
+		dto.NewGaugeMetrics("RandomValue", float64(rValue)),
+	}, nil
+}
+
+// VirtualMemoryCollector reports total and free memory of the host.
+type VirtualMemoryCollector struct {
+	interval time.Duration
+	read     func() (*mem.VirtualMemoryStat, error)
+}
+
+// NewVirtualMemoryCollector returns collector of the host memory statistics.
+func NewVirtualMemoryCollector(interval time.Duration) *VirtualMemoryCollector {
+	return &VirtualMemoryCollector{
+		interval: interval,
+		read:     mem.VirtualMemory,
+	}
+}
+
+func (c *VirtualMemoryCollector) Name() string { return "virtual_memory" }
+
+func (c *VirtualMemoryCollector) Interval() time.Duration { return c.interval }
+
+func (c *VirtualMemoryCollector) Collect(ctx context.Context) ([]dto.Metrics, error) {
+	v, err := c.read()
+	if err != nil {
+		return nil, err
+	}
+	return []dto.Metrics{
+		dto.NewGaugeMetrics("TotalMemory", float64(v.Total)),
+		dto.NewGaugeMetrics("FreeMemory", float64(v.Free)),
+	}, nil
+}
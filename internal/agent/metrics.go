@@ -1,44 +0,0 @@
-package agent
-
-var metricMainList = []string{
-	"Alloc",
-	"BuckHashSys",
-	"Frees",
-	"GCCPUFraction",
-	"GCSys",
-	"HeapAlloc",
-	"HeapIdle",
-	"HeapInuse",
-	"HeapObjects",
-	"HeapReleased",
-	"HeapSys",
-	"LastGC",
-	"Lookups",
-	"MCacheInuse",
-	"MCacheSys",
-	"MSpanInuse",
-	"MSpanSys",
-	"Mallocs",
-	"NextGC",
-	"NumForcedGC",
-	"NumGC",
-	"OtherSys",
-	"PauseTotalNs",
-	"StackInuse",
-	"StackSys",
-	"Sys",
-	"TotalAlloc",
-	"PollCount",
-	"RandomValue",
-}
-
-var metricSecondaryList = []string{
-	"TotalMemory",
-	"FreeMemory",
-	"CPUutilization1",
-}
-
-type Metric struct {
-	Name  string
-	Value interface{}
-}
@@ -0,0 +1,68 @@
+package agent
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/net"
+
+	dto "github.com/Jeskay/musthave_metrics/internal/Dto"
+)
+
+// NetworkCollector reports bytes, packets and errors of every network interface as counters.
+type NetworkCollector struct {
+	interval   time.Duration
+	filter     *nameFilter
+	ioCounters func(ctx context.Context, pernic bool) ([]net.IOCountersStat, error)
+	mu         sync.Mutex
+	io         *cumulativeCounters
+}
+
+// NewNetworkCollector returns collector of the network interface statistics
+// with interface include and exclude patterns.
+func NewNetworkCollector(interval time.Duration, include, exclude []string) (*NetworkCollector, error) {
+	filter, err := newNameFilter(include, exclude)
+	if err != nil {
+		return nil, err
+	}
+	return &NetworkCollector{
+		interval:   interval,
+		filter:     filter,
+		ioCounters: net.IOCountersWithContext,
+		io:         newCumulativeCounters(),
+	}, nil
+}
+
+func (c *NetworkCollector) Name() string { return "net" }
+
+func (c *NetworkCollector) Interval() time.Duration { return c.interval }
+
+func (c *NetworkCollector) Collect(ctx context.Context) ([]dto.Metrics, error) {
+	counters, err := c.ioCounters(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	metrics := make([]dto.Metrics, 0)
+	for _, io := range counters {
+		if !c.filter.Match(io.Name) {
+			continue
+		}
+		suffix := "_" + metricSuffix(io.Name)
+		for metric, value := range map[string]uint64{
+			"NetBytesSent" + suffix:   io.BytesSent,
+			"NetBytesRecv" + suffix:   io.BytesRecv,
+			"NetPacketsSent" + suffix: io.PacketsSent,
+			"NetPacketsRecv" + suffix: io.PacketsRecv,
+			"NetErrIn" + suffix:       io.Errin,
+			"NetErrOut" + suffix:      io.Errout,
+		} {
+			if delta, ok := c.io.Delta(metric, value); ok {
+				metrics = append(metrics, dto.NewCounterMetrics(metric, delta))
+			}
+		}
+	}
+	return metrics, nil
+}
@@ -0,0 +1,49 @@
+package agent
+
+import (
+	"context"
+	"testing"
+
+	"github.com/shirou/gopsutil/net"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNetworkCollector(t *testing.T) {
+	c, err := NewNetworkCollector(0, []string{"^eth"}, []string{"^eth1$"})
+	require.NoError(t, err)
+	readings := [][]net.IOCountersStat{
+		{
+			{Name: "eth0", BytesSent: 100, BytesRecv: 200, PacketsSent: 1, PacketsRecv: 2},
+			{Name: "eth1", BytesSent: 100},
+			{Name: "lo", BytesSent: 100},
+		},
+		{
+			{Name: "eth0", BytesSent: 150, BytesRecv: 260, PacketsSent: 3, PacketsRecv: 4, Errin: 1},
+			{Name: "eth1", BytesSent: 300},
+			{Name: "lo", BytesSent: 300},
+		},
+	}
+	call := 0
+	c.ioCounters = func(_ context.Context, pernic bool) ([]net.IOCountersStat, error) {
+		assert.True(t, pernic)
+		r := readings[call]
+		call++
+		return r, nil
+	}
+
+	metrics, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, metrics)
+
+	metrics, err = c.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{
+		"NetBytesSent_eth0":   50,
+		"NetBytesRecv_eth0":   60,
+		"NetPacketsSent_eth0": 2,
+		"NetPacketsRecv_eth0": 2,
+		"NetErrIn_eth0":       1,
+		"NetErrOut_eth0":      0,
+	}, counterValues(metrics))
+}
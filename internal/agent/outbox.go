@@ -0,0 +1,206 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	dto "github.com/Jeskay/musthave_metrics/internal/Dto"
+	"github.com/Jeskay/musthave_metrics/pkg/retry"
+)
+
+const (
+	outboxExt           = ".json"
+	outboxDepthMetric   = "AgentOutboxDepth"
+	outboxDroppedMetric = "AgentOutboxDropped"
+	retriesMetric       = "AgentRetries"
+)
+
+var errOutboxEntryTooLarge = errors.New("batch exceeds outbox size")
+
+// isTransient reports whether the request failed due to the server outage and can be repeated later.
+// Only network failures and server errors are transient. Failures to build the request
+// or to verify the server are repeated every time, so such batch would block the queue forever.
+func isTransient(err error) bool {
+	var se *retry.StatusError
+	if errors.As(err, &se) {
+		return se.Code >= http.StatusInternalServerError || se.Code == http.StatusTooManyRequests
+	}
+	return retry.IsNetworkError(err) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled)
+}
+
+// outboxEntry is the batch of metrics which was not delivered to the server.
+// Idempotency key of the original request is kept, so the server applies the batch
+// only once even if the original request has reached it.
+type outboxEntry struct {
+	Key     string        `json:"key"`
+	Metrics []dto.Metrics `json:"metrics"`
+}
+
+type outboxFile struct {
+	name    string
+	size    int64
+	created time.Time
+}
+
+// Outbox is the queue of undelivered batches stored in the directory, one file per batch.
+// The oldest batches are dropped when the total size of the queue exceeds the limit
+// and when they become older than the maximum age.
+type Outbox struct {
+	dir     string
+	maxSize int64
+	maxAge  time.Duration
+	mu      sync.Mutex
+	files   []outboxFile
+	size    int64
+	seq     uint64
+	dropped int64
+	now     func() time.Time
+}
+
+// NewOutbox opens the queue in the directory, creating it if necessary.
+// Batches left by the previous run are kept.
+func NewOutbox(dir string, maxSize int64, maxAge time.Duration) (*Outbox, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	o := &Outbox{dir: dir, maxSize: maxSize, maxAge: maxAge, now: time.Now}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, outboxExt), 10, 64)
+		if e.IsDir() || !strings.HasSuffix(name, outboxExt) || err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		o.files = append(o.files, outboxFile{name: name, size: info.Size(), created: info.ModTime()})
+		o.size += info.Size()
+		o.seq = max(o.seq, seq)
+	}
+	sort.Slice(o.files, func(i, j int) bool { return o.files[i].name < o.files[j].name })
+	return o, nil
+}
+
+// Push appends the batch to the end of the queue.
+func (o *Outbox) Push(entry outboxEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	size := int64(len(data))
+	if o.maxSize > 0 && size > o.maxSize {
+		o.dropped++
+		return errOutboxEntryTooLarge
+	}
+	o.expire()
+	for o.maxSize > 0 && len(o.files) > 0 && o.size+size > o.maxSize {
+		o.dropFirst()
+	}
+	o.seq++
+	name := fmt.Sprintf("%020d%s", o.seq, outboxExt)
+	tmp := filepath.Join(o.dir, name+".tmp")
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(o.dir, name)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	o.files = append(o.files, outboxFile{name: name, size: size, created: o.now()})
+	o.size += size
+	return nil
+}
+
+// Replay passes queued batches to send in order and removes the delivered ones.
+// It stops at the first batch which was not delivered. Unreadable batches are dropped.
+func (o *Outbox) Replay(send func(outboxEntry) error) error {
+	for {
+		o.mu.Lock()
+		o.expire()
+		if len(o.files) == 0 {
+			o.mu.Unlock()
+			return nil
+		}
+		file := o.files[0]
+		o.mu.Unlock()
+
+		data, err := os.ReadFile(filepath.Join(o.dir, file.name))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		var entry outboxEntry
+		if err != nil || json.Unmarshal(data, &entry) != nil {
+			o.remove(file.name, true)
+			continue
+		}
+		if err := send(entry); err != nil {
+			return err
+		}
+		o.remove(file.name, false)
+	}
+}
+
+// Len returns number of queued batches.
+func (o *Outbox) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.files)
+}
+
+// TakeDropped returns number of batches dropped since the previous call.
+func (o *Outbox) TakeDropped() int64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	dropped := o.dropped
+	o.dropped = 0
+	return dropped
+}
+
+func (o *Outbox) remove(name string, dropped bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.files) == 0 || o.files[0].name != name {
+		return
+	}
+	if dropped {
+		o.dropFirst()
+		return
+	}
+	os.Remove(filepath.Join(o.dir, name))
+	o.size -= o.files[0].size
+	o.files = o.files[1:]
+}
+
+// expire drops batches older than the maximum age.
+func (o *Outbox) expire() {
+	if o.maxAge <= 0 {
+		return
+	}
+	for len(o.files) > 0 && o.now().Sub(o.files[0].created) > o.maxAge {
+		o.dropFirst()
+	}
+}
+
+func (o *Outbox) dropFirst() {
+	os.Remove(filepath.Join(o.dir, o.files[0].name))
+	o.size -= o.files[0].size
+	o.files = o.files[1:]
+	o.dropped++
+}
@@ -0,0 +1,178 @@
+package agent
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Jeskay/musthave_metrics/config"
+	"github.com/Jeskay/musthave_metrics/internal"
+	dto "github.com/Jeskay/musthave_metrics/internal/Dto"
+	"github.com/Jeskay/musthave_metrics/pkg/retry"
+)
+
+func outboxBatch(key string) outboxEntry {
+	return outboxEntry{Key: key, Metrics: []dto.Metrics{dto.NewCounterMetrics("PollCount", 1)}}
+}
+
+func replayKeys(t *testing.T, o *Outbox) []string {
+	t.Helper()
+	var keys []string
+	require.NoError(t, o.Replay(func(e outboxEntry) error {
+		keys = append(keys, e.Key)
+		return nil
+	}))
+	return keys
+}
+
+func TestOutboxOrder(t *testing.T) {
+	dir := t.TempDir()
+	o, err := NewOutbox(dir, 0, 0)
+	require.NoError(t, err)
+	for _, key := range []string{"a", "b", "c"} {
+		require.NoError(t, o.Push(outboxBatch(key)))
+	}
+
+	errDown := errors.New("server is down")
+	var sent []string
+	err = o.Replay(func(e outboxEntry) error {
+		if e.Key == "b" {
+			return errDown
+		}
+		sent = append(sent, e.Key)
+		return nil
+	})
+	assert.ErrorIs(t, err, errDown)
+	assert.Equal(t, []string{"a"}, sent)
+	assert.Equal(t, 2, o.Len())
+
+	o, err = NewOutbox(dir, 0, 0)
+	require.NoError(t, err, "queue is restored from the directory")
+	require.NoError(t, o.Push(outboxBatch("d")))
+	assert.Equal(t, []string{"b", "c", "d"}, replayKeys(t, o))
+	assert.Zero(t, o.Len())
+	assert.Zero(t, o.TakeDropped())
+}
+
+func TestOutboxLimits(t *testing.T) {
+	data, err := json.Marshal(outboxBatch("a"))
+	require.NoError(t, err)
+	o, err := NewOutbox(t.TempDir(), int64(len(data))*2, time.Minute)
+	require.NoError(t, err)
+	now := time.Now()
+	o.now = func() time.Time { return now }
+
+	for _, key := range []string{"a", "b", "c"} {
+		require.NoError(t, o.Push(outboxBatch(key)))
+	}
+	assert.Equal(t, int64(1), o.TakeDropped(), "the oldest batch is dropped when size is exceeded")
+
+	now = now.Add(2 * time.Minute)
+	require.NoError(t, o.Push(outboxBatch("d")))
+	assert.Equal(t, int64(2), o.TakeDropped(), "expired batches are dropped")
+	assert.Equal(t, []string{"d"}, replayKeys(t, o))
+
+	large := outboxBatch("large")
+	for i := 0; i < 10; i++ {
+		large.Metrics = append(large.Metrics, dto.NewGaugeMetrics("Alloc", 1))
+	}
+	assert.ErrorIs(t, o.Push(large), errOutboxEntryTooLarge)
+	assert.Equal(t, int64(1), o.TakeDropped())
+}
+
+func TestIsTransient(t *testing.T) {
+	network := &url.Error{Op: "Post", URL: "http://localhost/updates", Err: syscall.ECONNREFUSED}
+	assert.True(t, isTransient(network))
+	assert.True(t, isTransient(fmt.Errorf("%w: %w", context.DeadlineExceeded, network)))
+	assert.True(t, isTransient(&retry.StatusError{Code: http.StatusServiceUnavailable}))
+	assert.True(t, isTransient(&retry.StatusError{Code: http.StatusTooManyRequests}))
+	assert.False(t, isTransient(&retry.StatusError{Code: http.StatusBadRequest}))
+	assert.False(t, isTransient(errors.New("crypto/rsa: message too long for RSA key size")), "request build failure is not transient")
+	certErr := &url.Error{Op: "Post", URL: "https://localhost/updates", Err: &tls.CertificateVerificationError{Err: x509.UnknownAuthorityError{}}}
+	assert.False(t, isTransient(certErr), "certificate verification failure is not transient")
+	assert.False(t, isTransient(&url.Error{Op: "Post", URL: "ftp://localhost/updates", Err: errors.New(`unsupported protocol scheme "ftp"`)}))
+	assert.False(t, isTransient(nil))
+}
+
+func TestSendMetricsSpoolsToOutbox(t *testing.T) {
+	var (
+		down atomic.Bool
+		mu   sync.Mutex
+		keys []string
+		got  int64
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if r.URL.Path == "/ping" {
+			return
+		}
+		metrics, ok := decodeMetrics(t, r)
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		keys = append(keys, r.Header.Get(internal.IdempotencyHeader))
+		for _, m := range metrics {
+			if m.ID == "PollCount" {
+				got += *m.Delta
+			}
+		}
+	}))
+	defer server.Close()
+
+	conf := &config.AgentConfig{Address: server.URL, RateLimit: 1, OutboxDir: t.TempDir()}
+	svc := NewAgentService(server.Client(), conf, slog.NewTextHandler(os.Stdout, nil))
+	require.NotNil(t, svc.routes[0].outbox)
+	send := func(delta int64) {
+		svc.counters.Add("PollCount", delta)
+		reqs := make(chan *report, 1)
+		go func() {
+			svc.replayOutbox(svc.routes[0])
+			svc.PrepareMetricsBatch(svc.routes[0], []string{"PollCount"}, reqs, 8, 64<<10)
+			close(reqs)
+		}()
+		svc.SendMetrics(context.Background(), reqs)
+	}
+
+	down.Store(true)
+	send(1)
+	send(2)
+	assert.Equal(t, 2, svc.routes[0].outbox.Len())
+	_, ok := svc.counters.Get("PollCount")
+	assert.False(t, ok, "spooled deltas are not restored")
+	_, ok = svc.counters.Get(outboxDroppedMetric)
+	assert.False(t, ok, "no batches are dropped")
+	depth, ok := svc.storage.Get(outboxDepthMetric)
+	require.True(t, ok)
+	assert.Equal(t, float64(1), *depth.Value)
+
+	down.Store(false)
+	send(4)
+	assert.Zero(t, svc.routes[0].outbox.Len())
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, int64(7), got)
+	require.Len(t, keys, 3)
+	assert.NotEqual(t, keys[0], keys[1])
+}
@@ -0,0 +1,155 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/process"
+
+	"github.com/Jeskay/musthave_metrics/config"
+	dto "github.com/Jeskay/musthave_metrics/internal/Dto"
+)
+
+var errProcessNotFound = errors.New("process not found")
+
+type processTarget struct {
+	config.ProcessTarget
+	prefix  string
+	cmdline *regexp.Regexp
+	proc    *process.Process
+}
+
+// ProcessCollector reports resource usage of the watched processes.
+// Processes are found by PID file, exact name or command line pattern and found again
+// after they restart. Metric names are prefixed with the target prefix or process name.
+type ProcessCollector struct {
+	interval time.Duration
+	mu       sync.Mutex
+	targets  []*processTarget
+}
+
+// NewProcessCollector returns collector of the specified processes.
+func NewProcessCollector(interval time.Duration, targets []config.ProcessTarget) (*ProcessCollector, error) {
+	c := &ProcessCollector{interval: interval}
+	for _, t := range targets {
+		if t.PIDFile == "" && t.Name == "" && t.Cmdline == "" {
+			return nil, errors.New("process target must have PID file, name or command line")
+		}
+		target := &processTarget{ProcessTarget: t, prefix: t.Prefix}
+		if t.Cmdline != "" {
+			re, err := regexp.Compile(t.Cmdline)
+			if err != nil {
+				return nil, err
+			}
+			target.cmdline = re
+		}
+		if target.prefix == "" {
+			switch {
+			case t.Name != "":
+				target.prefix = metricSuffix(t.Name)
+			case t.PIDFile != "":
+				target.prefix = metricSuffix(strings.TrimSuffix(filepath.Base(t.PIDFile), filepath.Ext(t.PIDFile)))
+			default:
+				target.prefix = metricSuffix(t.Cmdline)
+			}
+		}
+		c.targets = append(c.targets, target)
+	}
+	return c, nil
+}
+
+func (c *ProcessCollector) Name() string { return "process" }
+
+func (c *ProcessCollector) Interval() time.Duration { return c.interval }
+
+func (c *ProcessCollector) Collect(ctx context.Context) ([]dto.Metrics, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	metrics := make([]dto.Metrics, 0, len(c.targets)*6)
+	var errs []error
+	for _, t := range c.targets {
+		m, err := c.collectTarget(ctx, t)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		metrics = append(metrics, m...)
+	}
+	return metrics, errors.Join(errs...)
+}
+
+func (c *ProcessCollector) collectTarget(ctx context.Context, t *processTarget) ([]dto.Metrics, error) {
+	running := false
+	if t.proc != nil {
+		running, _ = t.proc.IsRunningWithContext(ctx)
+	}
+	if !running {
+		proc, err := t.resolve(ctx)
+		if err != nil {
+			t.proc = nil
+			return []dto.Metrics{dto.NewGaugeMetrics(t.prefix+"_Running", 0)}, err
+		}
+		t.proc = proc
+	}
+	metrics := []dto.Metrics{dto.NewGaugeMetrics(t.prefix+"_Running", 1)}
+	if mem, err := t.proc.MemoryInfoWithContext(ctx); err == nil {
+		metrics = append(metrics, dto.NewGaugeMetrics(t.prefix+"_RSS", float64(mem.RSS)))
+	}
+	if percent, err := t.proc.PercentWithContext(ctx, 0); err == nil {
+		metrics = append(metrics, dto.NewGaugeMetrics(t.prefix+"_CPUPercent", percent))
+	}
+	if fds, err := t.proc.NumFDsWithContext(ctx); err == nil {
+		metrics = append(metrics, dto.NewGaugeMetrics(t.prefix+"_OpenFDs", float64(fds)))
+	}
+	if threads, err := t.proc.NumThreadsWithContext(ctx); err == nil {
+		metrics = append(metrics, dto.NewGaugeMetrics(t.prefix+"_Threads", float64(threads)))
+	}
+	if created, err := t.proc.CreateTimeWithContext(ctx); err == nil {
+		uptime := time.Since(time.UnixMilli(created)).Seconds()
+		metrics = append(metrics, dto.NewGaugeMetrics(t.prefix+"_Uptime", uptime))
+	}
+	return metrics, nil
+}
+
+// resolve finds process of the target by PID file, name or command line.
+func (t *processTarget) resolve(ctx context.Context) (*process.Process, error) {
+	if t.PIDFile != "" {
+		b, err := os.ReadFile(t.PIDFile)
+		if err != nil {
+			return nil, err
+		}
+		pid, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		return process.NewProcessWithContext(ctx, int32(pid))
+	}
+	procs, err := process.ProcessesWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	self := int32(os.Getpid())
+	for _, p := range procs {
+		if p.Pid == self {
+			continue
+		}
+		if t.Name != "" {
+			if name, err := p.NameWithContext(ctx); err != nil || name != t.Name {
+				continue
+			}
+		}
+		if t.cmdline != nil {
+			if cmdline, err := p.CmdlineWithContext(ctx); err != nil || !t.cmdline.MatchString(cmdline) {
+				continue
+			}
+		}
+		return p, nil
+	}
+	return nil, errProcessNotFound
+}
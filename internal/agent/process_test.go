@@ -0,0 +1,113 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Jeskay/musthave_metrics/config"
+)
+
+// startSleep starts the sleep process which is killed when the test finishes.
+func startSleep(t *testing.T, seconds string) *exec.Cmd {
+	cmd := exec.Command("sleep", seconds)
+	if err := cmd.Start(); err != nil {
+		t.Skip("sleep is not available:", err)
+	}
+	t.Cleanup(func() { stopProcess(cmd) })
+	return cmd
+}
+
+// stopProcess kills the process and waits for it, so it is not left as zombie.
+func stopProcess(cmd *exec.Cmd) {
+	if cmd.ProcessState == nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}
+}
+
+func writePIDFile(t *testing.T, path string, cmd *exec.Cmd) {
+	require.NoError(t, os.WriteFile(path, []byte(fmt.Sprintf("%d\n", cmd.Process.Pid)), 0o600))
+}
+
+func TestProcessCollectorPrefix(t *testing.T) {
+	c, err := NewProcessCollector(0, []config.ProcessTarget{
+		{Name: "postgres"},
+		{PIDFile: "/run/nginx.pid"},
+		{Cmdline: "java -jar app.jar"},
+		{Name: "redis-server", Prefix: "Cache"},
+	})
+	require.NoError(t, err)
+	prefixes := make([]string, 0, len(c.targets))
+	for _, target := range c.targets {
+		prefixes = append(prefixes, target.prefix)
+	}
+	assert.Equal(t, []string{"postgres", "nginx", "java_jar_app_jar", "Cache"}, prefixes)
+
+	_, err = NewProcessCollector(0, []config.ProcessTarget{{Prefix: "empty"}})
+	assert.Error(t, err)
+	_, err = NewProcessCollector(0, []config.ProcessTarget{{Cmdline: "("}})
+	assert.Error(t, err)
+}
+
+func TestProcessCollectorResolve(t *testing.T) {
+	cmd := startSleep(t, "30.5")
+	pidFile := filepath.Join(t.TempDir(), "sleep.pid")
+	writePIDFile(t, pidFile, cmd)
+
+	c, err := NewProcessCollector(0, []config.ProcessTarget{
+		{PIDFile: pidFile},
+		{Name: "sleep", Prefix: "ByName"},
+		{Cmdline: `^sleep 30\.5$`, Prefix: "ByCmdline"},
+	})
+	require.NoError(t, err)
+	metrics, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	values := gaugeValues(metrics)
+	for _, prefix := range []string{"sleep", "ByName", "ByCmdline"} {
+		assert.Equal(t, 1.0, values[prefix+"_Running"], prefix)
+		assert.Contains(t, values, prefix+"_RSS", prefix)
+		assert.Contains(t, values, prefix+"_Threads", prefix)
+	}
+	assert.Equal(t, int32(cmd.Process.Pid), c.targets[0].proc.Pid)
+	assert.Equal(t, int32(cmd.Process.Pid), c.targets[2].proc.Pid)
+}
+
+func TestProcessCollectorRestart(t *testing.T) {
+	first := startSleep(t, "30.25")
+	pidFile := filepath.Join(t.TempDir(), "sleep.pid")
+	writePIDFile(t, pidFile, first)
+
+	c, err := NewProcessCollector(0, []config.ProcessTarget{
+		{PIDFile: pidFile},
+		{Cmdline: `^sleep 30\.25$`, Prefix: "ByCmdline"},
+	})
+	require.NoError(t, err)
+	metrics, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, gaugeValues(metrics)["sleep_Running"])
+
+	stopProcess(first)
+	require.NoError(t, os.Remove(pidFile))
+	metrics, err = c.Collect(context.Background())
+	assert.Error(t, err)
+	values := gaugeValues(metrics)
+	assert.Zero(t, values["sleep_Running"])
+	assert.Zero(t, values["ByCmdline_Running"])
+
+	second := startSleep(t, "30.25")
+	writePIDFile(t, pidFile, second)
+	metrics, err = c.Collect(context.Background())
+	require.NoError(t, err)
+	values = gaugeValues(metrics)
+	assert.Equal(t, 1.0, values["sleep_Running"])
+	assert.Equal(t, 1.0, values["ByCmdline_Running"])
+	assert.Equal(t, int32(second.Process.Pid), c.targets[0].proc.Pid, "process is found again after restart")
+	assert.Equal(t, int32(second.Process.Pid), c.targets[1].proc.Pid)
+}
@@ -0,0 +1,109 @@
+package agent
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/Jeskay/musthave_metrics/internal"
+	dto "github.com/Jeskay/musthave_metrics/internal/Dto"
+)
+
+// listen opens TCP listener or Unix socket if the address is prefixed with "unix:".
+// TCP address is required to be a loopback one.
+func listen(address string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(address, "unix:"); ok {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		return net.Listen("unix", path)
+	}
+	if err := checkLoopback(address); err != nil {
+		return nil, err
+	}
+	return net.Listen("tcp", address)
+}
+
+// PushHandler returns handler of the local push endpoints, which accept custom metrics of the applications
+// on the same host in the same JSON format as the metric server does.
+// Received metrics are merged into agent's storage and forwarded with the collected ones.
+//
+//	POST /update/  accepts single metric
+//	POST /updates  accepts list of metrics
+//
+// Request body can be compressed using gzip.
+func (svc *AgentService) PushHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /update/", func(w http.ResponseWriter, r *http.Request) {
+		var metric dto.Metrics
+		if err := decodePushBody(r, &metric); err != nil || !validPushMetric(metric) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		svc.store([]dto.Metrics{metric})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(metric)
+	})
+	mux.HandleFunc("POST /updates", func(w http.ResponseWriter, r *http.Request) {
+		var metrics []dto.Metrics
+		if err := decodePushBody(r, &metrics); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		for _, m := range metrics {
+			if !validPushMetric(m) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+		svc.store(metrics)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(metrics)
+	})
+	return mux
+}
+
+func decodePushBody(r *http.Request, v any) error {
+	body := r.Body
+	if strings.Contains(r.Header.Get("Content-Encoding"), "gzip") {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		body = gz
+	}
+	return json.NewDecoder(io.LimitReader(body, 1<<20)).Decode(v)
+}
+
+func validPushMetric(m dto.Metrics) bool {
+	if m.ID == "" {
+		return false
+	}
+	switch internal.MetricType(m.MType) {
+	case internal.CounterMetric:
+		return m.Delta != nil
+	case internal.GaugeMetric:
+		return m.Value != nil
+	}
+	return false
+}
+
+func checkLoopback(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return errors.New("local address must be a loopback address or a unix socket")
+}
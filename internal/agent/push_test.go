@@ -0,0 +1,51 @@
+package agent
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Jeskay/musthave_metrics/config"
+)
+
+func TestPushHandler(t *testing.T) {
+	conf := &config.AgentConfig{Address: "localhost:3000"}
+	svc := NewAgentService(http.DefaultClient, conf, slog.NewTextHandler(os.Stdout, nil))
+	handler := svc.PushHandler()
+
+	tests := []struct {
+		name   string
+		path   string
+		body   string
+		status int
+	}{
+		{"single gauge", "/update/", `{"id":"QueueSize","type":"gauge","value":12.5}`, http.StatusOK},
+		{"batch", "/updates", `[{"id":"Jobs","type":"counter","delta":2},{"id":"Jobs","type":"counter","delta":3}]`, http.StatusOK},
+		{"missing value", "/update/", `{"id":"Broken","type":"gauge"}`, http.StatusBadRequest},
+		{"unknown type", "/updates", `[{"id":"Broken","type":"histogram","value":1}]`, http.StatusBadRequest},
+		{"invalid json", "/updates", `{`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			handler.ServeHTTP(w, r)
+			assert.Equal(t, tt.status, w.Code)
+		})
+	}
+
+	m, ok := svc.storage.Get("QueueSize")
+	require.True(t, ok)
+	assert.Equal(t, 12.5, *m.Value)
+	m, ok = svc.counters.Get("Jobs")
+	require.True(t, ok)
+	assert.Equal(t, int64(5), *m.Delta)
+	_, ok = svc.storage.Get("Broken")
+	assert.False(t, ok)
+}
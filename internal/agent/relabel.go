@@ -0,0 +1,182 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Jeskay/musthave_metrics/config"
+	dto "github.com/Jeskay/musthave_metrics/internal/Dto"
+)
+
+// Actions of the relabel rules.
+const (
+	relabelKeep     = "keep"
+	relabelDrop     = "drop"
+	relabelReplace  = "replace"
+	relabelRename   = "rename"
+	relabelLabelAdd = "label-add"
+)
+
+type relabelRule struct {
+	action      string
+	re          *regexp.Regexp
+	mtype       string
+	replacement string
+	label       string
+}
+
+// relabeler applies relabel rules to the collected metrics in order.
+// Metrics dropped by a rule are not passed to the following ones.
+type relabeler struct {
+	rules []relabelRule
+}
+
+// newRelabeler compiles the rules. Agent variables in the replacements are substituted with their values.
+func newRelabeler(rules []config.RelabelRule, vars map[string]string) (*relabeler, error) {
+	pairs := make([]string, 0, 2*len(vars))
+	for k, v := range vars {
+		pairs = append(pairs, "${"+k+"}", strings.ReplaceAll(v, "$", "$$"))
+	}
+	expand := strings.NewReplacer(pairs...)
+	r := &relabeler{rules: make([]relabelRule, 0, len(rules))}
+	for i, rule := range rules {
+		compiled := relabelRule{
+			action:      rule.Action,
+			mtype:       rule.Type,
+			replacement: expand.Replace(rule.Replacement),
+			label:       rule.Label,
+		}
+		pattern := rule.Regex
+		switch rule.Action {
+		case relabelKeep, relabelDrop, relabelRename:
+		case relabelReplace:
+			if pattern == "" {
+				return nil, fmt.Errorf("relabel rule %d: regex is required by replace action", i)
+			}
+		case relabelLabelAdd:
+			if rule.Label == "" {
+				return nil, fmt.Errorf("relabel rule %d: label is required by label-add action", i)
+			}
+		default:
+			return nil, fmt.Errorf("relabel rule %d: unknown action %q", i, rule.Action)
+		}
+		if rule.Action != relabelReplace {
+			if pattern == "" {
+				pattern = ".*"
+			}
+			pattern = "^(?:" + pattern + ")$"
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("relabel rule %d: %w", i, err)
+		}
+		compiled.re = re
+		r.rules = append(r.rules, compiled)
+	}
+	return r, nil
+}
+
+// apply returns the metric transformed by the rules and the index of the rule
+// which dropped it, or -1 if the metric is kept.
+func (r *relabeler) apply(m dto.Metrics) (dto.Metrics, int) {
+	for i, rule := range r.rules {
+		if rule.mtype != "" && rule.mtype != m.MType {
+			continue
+		}
+		match := rule.re.FindStringSubmatchIndex(m.ID)
+		switch rule.action {
+		case relabelKeep:
+			if match == nil {
+				return m, i
+			}
+		case relabelDrop:
+			if match != nil {
+				return m, i
+			}
+		case relabelReplace:
+			m.ID = rule.re.ReplaceAllString(m.ID, rule.replacement)
+		case relabelRename:
+			if match != nil {
+				if name := string(rule.re.ExpandString(nil, rule.replacement, m.ID, match)); name != "" {
+					m.ID = name
+				}
+			}
+		case relabelLabelAdd:
+			if match != nil {
+				if value := string(rule.re.ExpandString(nil, rule.replacement, m.ID, match)); value != "" {
+					m.ID += "_" + rule.label + "_" + metricSuffix(value)
+				}
+			}
+		}
+	}
+	return m, -1
+}
+
+// Apply returns metrics transformed by the rules without the dropped ones.
+func (r *relabeler) Apply(metrics []dto.Metrics) []dto.Metrics {
+	if r == nil || len(r.rules) == 0 {
+		return metrics
+	}
+	kept := make([]dto.Metrics, 0, len(metrics))
+	for _, m := range metrics {
+		if m, dropped := r.apply(m); dropped < 0 && m.ID != "" {
+			kept = append(kept, m)
+		}
+	}
+	return kept
+}
+
+// RelabelDryRun runs enabled collectors once and writes effects of the relabel rules
+// on the collected metrics without storing them.
+func (svc *AgentService) RelabelDryRun(ctx context.Context, w io.Writer) error {
+	if svc.rulesErr != nil {
+		return svc.rulesErr
+	}
+	collectors, err := svc.registry.Enabled(svc.config.EnabledCollectors())
+	if err != nil {
+		return err
+	}
+	metrics := make([]dto.Metrics, 0)
+	for _, c := range collectors {
+		collected, err := c.Collect(ctx)
+		if err != nil {
+			svc.logger.Error("collector failed", slog.String("collector", c.Name()), slog.String("error", err.Error()))
+		}
+		metrics = append(metrics, collected...)
+	}
+	slices.SortFunc(metrics, func(a, b dto.Metrics) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "METRIC\tTYPE\tRESULT")
+	for _, m := range metrics {
+		out, dropped := svc.relabel.apply(m)
+		var result string
+		switch {
+		case dropped >= 0:
+			result = fmt.Sprintf("dropped by rule %d (%s)", dropped, svc.relabel.rules[dropped].action)
+		case out.ID == m.ID:
+			result = "kept"
+		default:
+			result = "-> " + out.ID
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", m.ID, m.MType, result)
+	}
+	return tw.Flush()
+}
+
+// relabelVars returns values of the agent variables available to the relabel rules.
+func relabelVars(conf *config.AgentConfig) map[string]string {
+	host, _ := os.Hostname()
+	return map[string]string{
+		"hostname": host,
+		"agent_id": conf.GetAgentID(),
+	}
+}
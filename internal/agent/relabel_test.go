@@ -0,0 +1,147 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Jeskay/musthave_metrics/config"
+	dto "github.com/Jeskay/musthave_metrics/internal/Dto"
+)
+
+func TestRelabeler(t *testing.T) {
+	vars := map[string]string{"hostname": "web-1", "agent_id": "a1"}
+	tests := []struct {
+		name  string
+		rules []config.RelabelRule
+		in    dto.Metrics
+		want  string
+		kept  bool
+	}{
+		{
+			"drop",
+			[]config.RelabelRule{{Action: "drop", Regex: "BuckHashSys"}},
+			dto.NewGaugeMetrics("BuckHashSys", 1), "", false,
+		},
+		{
+			"drop matches whole name",
+			[]config.RelabelRule{{Action: "drop", Regex: "Buck"}},
+			dto.NewGaugeMetrics("BuckHashSys", 1), "BuckHashSys", true,
+		},
+		{
+			"keep",
+			[]config.RelabelRule{{Action: "keep", Regex: "Heap.*"}},
+			dto.NewGaugeMetrics("StackSys", 1), "", false,
+		},
+		{
+			"keep other type",
+			[]config.RelabelRule{{Action: "keep", Regex: "Heap.*", Type: "gauge"}},
+			dto.NewCounterMetrics("PollCount", 1), "PollCount", true,
+		},
+		{
+			"replace",
+			[]config.RelabelRule{{Action: "replace", Regex: "Sys", Replacement: "_system"}},
+			dto.NewGaugeMetrics("HeapSys", 1), "Heap_system", true,
+		},
+		{
+			"rename with groups",
+			[]config.RelabelRule{{Action: "rename", Regex: "Heap(.*)", Replacement: "heap_${1}"}},
+			dto.NewGaugeMetrics("HeapAlloc", 1), "heap_Alloc", true,
+		},
+		{
+			"host prefix",
+			[]config.RelabelRule{{Action: "rename", Replacement: "${hostname}_$0"}},
+			dto.NewGaugeMetrics("Alloc", 1), "web-1_Alloc", true,
+		},
+		{
+			"label add",
+			[]config.RelabelRule{{Action: "label-add", Label: "agent", Replacement: "${agent_id}"}},
+			dto.NewCounterMetrics("PollCount", 1), "PollCount_agent_a1", true,
+		},
+		{
+			"rules applied in order",
+			[]config.RelabelRule{
+				{Action: "rename", Regex: "HeapAlloc", Replacement: "heap_alloc"},
+				{Action: "drop", Regex: "Heap.*"},
+			},
+			dto.NewGaugeMetrics("HeapAlloc", 1), "heap_alloc", true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := newRelabeler(tt.rules, vars)
+			require.NoError(t, err)
+			out := r.Apply([]dto.Metrics{tt.in})
+			if !tt.kept {
+				assert.Empty(t, out)
+				return
+			}
+			require.Len(t, out, 1)
+			assert.Equal(t, tt.want, out[0].ID)
+		})
+	}
+}
+
+func TestRelabelerInvalid(t *testing.T) {
+	rules := [][]config.RelabelRule{
+		{{Action: "hashmod"}},
+		{{Action: "replace"}},
+		{{Action: "label-add", Replacement: "x"}},
+		{{Action: "drop", Regex: "("}},
+	}
+	for _, r := range rules {
+		_, err := newRelabeler(r, nil)
+		assert.Error(t, err)
+	}
+}
+
+func TestRelabelDryRun(t *testing.T) {
+	conf := &config.AgentConfig{
+		Address:    "localhost:3000",
+		Collectors: []string{"stub"},
+		Relabel: []config.RelabelRule{
+			{Action: "drop", Regex: "BuckHashSys"},
+			{Action: "rename", Regex: "HeapAlloc", Replacement: "heap_alloc"},
+		},
+	}
+	svc := NewAgentService(http.DefaultClient, conf, slog.NewTextHandler(os.Stdout, nil))
+	svc.Registry().Register(&stubCollector{metrics: []dto.Metrics{
+		dto.NewGaugeMetrics("HeapAlloc", 1),
+		dto.NewGaugeMetrics("BuckHashSys", 2),
+		dto.NewCounterMetrics("PollCount", 1),
+	}})
+
+	var out bytes.Buffer
+	require.NoError(t, svc.RelabelDryRun(context.Background(), &out))
+	assert.Equal(t, "METRIC       TYPE     RESULT\n"+
+		"BuckHashSys  gauge    dropped by rule 0 (drop)\n"+
+		"HeapAlloc    gauge    -> heap_alloc\n"+
+		"PollCount    counter  kept\n", out.String())
+	_, ok := svc.storage.Get("heap_alloc")
+	assert.False(t, ok)
+
+	svc.Collect(context.Background(), &stubCollector{metrics: []dto.Metrics{dto.NewGaugeMetrics("HeapAlloc", 3), dto.NewGaugeMetrics("BuckHashSys", 4)}})
+	m, ok := svc.storage.Get("heap_alloc")
+	require.True(t, ok)
+	assert.Equal(t, 3.0, *m.Value)
+	_, ok = svc.storage.Get("BuckHashSys")
+	assert.False(t, ok)
+}
+
+func TestRunInvalidRelabelRules(t *testing.T) {
+	conf := &config.AgentConfig{
+		Address: "localhost:3000",
+		Relabel: []config.RelabelRule{{Action: "drop", Regex: "Buck(HashSys"}},
+	}
+	svc := NewAgentService(http.DefaultClient, conf, slog.NewTextHandler(os.Stdout, nil))
+	svc.clock = newFakeClock()
+	assert.ErrorContains(t, svc.Run(context.Background()), "invalid relabel rules", "agent does not start with invalid rules")
+	assert.Error(t, svc.RelabelDryRun(context.Background(), io.Discard))
+}
@@ -0,0 +1,96 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+
+	dto "github.com/Jeskay/musthave_metrics/internal/Dto"
+	"github.com/Jeskay/musthave_metrics/pkg/retry"
+)
+
+// Names of the settings which can be changed remotely and locked locally.
+const (
+	settingReportInterval = "report_interval"
+	settingPollInterval   = "poll_interval"
+	settingRateLimit      = "rate_limit"
+)
+
+// PollConfig requests the agent settings from the current destination of the first route and applies the changed ones.
+// Settings are requested only if they were modified since the previous poll.
+func (svc *AgentService) PollConfig() error {
+	query := url.Values{"id": {svc.config.GetAgentID()}, "group": {svc.config.AgentGroup}}
+	req, err := http.NewRequest(http.MethodGet, svc.routes[0].current().url+"/agent/config?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	svc.writeRealIP(req)
+	svc.mu.RLock()
+	if svc.configETag != "" {
+		req.Header.Set("If-None-Match", svc.configETag)
+	}
+	svc.mu.RUnlock()
+	res, err := svc.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return nil
+	default:
+		return &retry.StatusError{Code: res.StatusCode}
+	}
+	var settings dto.AgentSettings
+	if err := json.NewDecoder(res.Body).Decode(&settings); err != nil {
+		return err
+	}
+	svc.applySettings(settings)
+	svc.mu.Lock()
+	svc.configETag = res.Header.Get("ETag")
+	svc.mu.Unlock()
+	return nil
+}
+
+// applySettings updates the configuration, restarts tickers and resizes the worker pool.
+// Locked settings and invalid values are ignored.
+func (svc *AgentService) applySettings(settings dto.AgentSettings) {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	if v, ok := svc.changedSetting(settingReportInterval, settings.ReportInterval, svc.config.ReportInterval); ok {
+		svc.config.ReportInterval = v
+		if svc.reportTicker != nil {
+			svc.reportTicker.Reset(svc.config.GetReportInterval())
+		}
+	}
+	if v, ok := svc.changedSetting(settingPollInterval, settings.PollInterval, svc.config.PollInterval); ok {
+		svc.config.PollInterval = v
+		if svc.pollTicker != nil {
+			svc.pollTicker.Reset(svc.config.GetPollInterval())
+		}
+	}
+	if v, ok := svc.changedSetting(settingRateLimit, settings.RateLimit, svc.config.RateLimit); ok {
+		svc.config.RateLimit = v
+		svc.workerPool.Resize(v)
+	}
+}
+
+func (svc *AgentService) changedSetting(name string, value *int, current int) (int, bool) {
+	if value == nil || *value == current {
+		return 0, false
+	}
+	if slices.Contains(svc.config.LockedSettings, name) {
+		svc.logger.Debug("remote setting is locked", slog.String("setting", name))
+		return 0, false
+	}
+	if *value <= 0 {
+		svc.logger.Warn("invalid remote setting", slog.String("setting", name), slog.Int("value", *value))
+		return 0, false
+	}
+	svc.logger.Info("remote setting applied", slog.String("setting", name), slog.String("value", fmt.Sprint(*value)))
+	return *value, true
+}
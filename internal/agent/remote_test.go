@@ -0,0 +1,67 @@
+package agent
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Jeskay/musthave_metrics/config"
+	dto "github.com/Jeskay/musthave_metrics/internal/Dto"
+)
+
+func TestPollConfig(t *testing.T) {
+	report, poll, rate := 5, 3, 4
+	settings := dto.AgentSettings{ReportInterval: &report, PollInterval: &poll, RateLimit: &rate}
+	var fetched atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !assert.Equal(t, "/agent/config", r.URL.Path) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		assert.Equal(t, "host-1", r.URL.Query().Get("id"))
+		assert.Equal(t, "web", r.URL.Query().Get("group"))
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		fetched.Add(1)
+		json.NewEncoder(w).Encode(settings)
+	}))
+	defer server.Close()
+
+	conf := &config.AgentConfig{
+		Address:        server.URL,
+		AgentID:        "host-1",
+		AgentGroup:     "web",
+		ReportInterval: 10,
+		PollInterval:   2,
+		RateLimit:      1,
+		LockedSettings: []string{"poll_interval"},
+	}
+	svc := NewAgentService(server.Client(), conf, slog.NewTextHandler(os.Stdout, nil))
+
+	require.NoError(t, svc.PollConfig())
+	assert.Equal(t, 5, conf.ReportInterval)
+	assert.Equal(t, 2, conf.PollInterval, "locked setting is not changed")
+	assert.Equal(t, 4, svc.workerPool.Size())
+
+	require.NoError(t, svc.PollConfig())
+	assert.Equal(t, int64(1), fetched.Load(), "unchanged settings are not fetched again")
+}
+
+func TestApplySettingsIgnoresInvalid(t *testing.T) {
+	conf := &config.AgentConfig{Address: "localhost:3000", ReportInterval: 10, RateLimit: 2}
+	svc := NewAgentService(http.DefaultClient, conf, slog.NewTextHandler(os.Stdout, nil))
+	zero := 0
+	svc.applySettings(dto.AgentSettings{ReportInterval: &zero, RateLimit: &zero})
+	assert.Equal(t, 10, conf.ReportInterval)
+	assert.Equal(t, 2, svc.workerPool.Size())
+}
@@ -0,0 +1,20 @@
+package request
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/Jeskay/musthave_metrics/internal"
+)
+
+// WriteIdempotencyKey adds random key to the request, so the server
+// applies it only once no matter how many times it is retried.
+func WriteIdempotencyKey(req *http.Request) error {
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	req.Header.Set(internal.IdempotencyHeader, hex.EncodeToString(key))
+	return nil
+}
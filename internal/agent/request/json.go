@@ -28,6 +28,9 @@ func MetricPostJson(hashKey string, cipherService *Cipher, metric dto.Metrics, u
 	if err := WriteHash(req, buf.Bytes(), hashKey); err != nil {
 		return req, err
 	}
+	if err := WriteIdempotencyKey(req); err != nil {
+		return req, err
+	}
 	req.Header.Set("Content-Encoding", "gzip")
 	req.Header.Set("Accept-Encoding", "gzip")
 	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
@@ -60,6 +63,9 @@ func MetricsPostJson(hashKey string, cipherService *Cipher, metrics []dto.Metric
 	if err := WriteHash(req, buf.Bytes(), hashKey); err != nil {
 		return req, err
 	}
+	if err := WriteIdempotencyKey(req); err != nil {
+		return req, err
+	}
 	req.Header.Set("Content-Encoding", "gzip")
 	req.Header.Set("Accept-Encoding", "gzip")
 	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
@@ -0,0 +1,211 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// clock provides time and tickers to the agent, so tests can drive it without waiting.
+type clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) ticker
+}
+
+// ticker is the part of time.Ticker used by the agent.
+type ticker interface {
+	C() <-chan time.Time
+	Reset(d time.Duration)
+	Stop()
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTicker(d time.Duration) ticker { return realTicker{time.NewTicker(d)} }
+
+type realTicker struct{ *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.Ticker.C }
+
+// Run collects metrics every poll interval and sends them to the servers every report interval
+// until the context is canceled. Local push and status endpoints are served and the settings are polled
+// from the server if they are configured. On cancellation the collectors are run once more and all pending
+// metrics are sent within the shutdown timeout. Run returns the first error which stopped the agent.
+// The agent does not start if the configured rules are invalid.
+func (svc *AgentService) Run(ctx context.Context) error {
+	if svc.rulesErr != nil {
+		return svc.rulesErr
+	}
+	collectors, err := svc.registry.Enabled(svc.config.EnabledCollectors())
+	if err != nil {
+		return err
+	}
+	g, gctx := errgroup.WithContext(ctx)
+	scheduled := make([]Collector, 0, len(collectors))
+	for _, c := range collectors {
+		if c.Interval() == 0 {
+			scheduled = append(scheduled, c)
+			continue
+		}
+		g.Go(func() error {
+			svc.runCollector(gctx, c)
+			return nil
+		})
+	}
+	g.Go(func() error {
+		svc.collectLoop(gctx, scheduled)
+		return nil
+	})
+	g.Go(func() error {
+		svc.sendLoop(gctx)
+		return nil
+	})
+	if svc.config.RemoteConfigInterval > 0 {
+		g.Go(func() error {
+			svc.configLoop(gctx, svc.config.GetRemoteConfigInterval())
+			return nil
+		})
+	}
+	if svc.config.PushAddress != "" {
+		g.Go(func() error {
+			return svc.serveLocal(gctx, svc.config.PushAddress, svc.PushHandler())
+		})
+	}
+	if svc.config.StatusAddress != "" {
+		g.Go(func() error {
+			return svc.serveLocal(gctx, svc.config.StatusAddress, svc.StatusHandler())
+		})
+	}
+	err = g.Wait()
+
+	svc.logger.Info("flushing metrics before shutdown")
+	flushCtx, cancel := context.WithTimeout(context.Background(), svc.config.GetShutdownTimeout())
+	defer cancel()
+	for _, c := range collectors {
+		svc.Collect(flushCtx, c)
+	}
+	svc.send(flushCtx)
+	return err
+}
+
+// collectLoop runs the collectors every poll interval.
+func (svc *AgentService) collectLoop(ctx context.Context, collectors []Collector) {
+	svc.mu.Lock()
+	svc.pollTicker = svc.clock.NewTicker(svc.config.GetPollInterval())
+	t := svc.pollTicker
+	svc.mu.Unlock()
+	defer t.Stop()
+	for {
+		for _, c := range collectors {
+			svc.Collect(ctx, c)
+		}
+		select {
+		case <-t.C():
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// runCollector runs the collector with its own interval.
+func (svc *AgentService) runCollector(ctx context.Context, c Collector) {
+	t := svc.clock.NewTicker(c.Interval())
+	defer t.Stop()
+	for {
+		svc.Collect(ctx, c)
+		select {
+		case <-t.C():
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sendLoop sends collected metrics every report interval. Every route delivers metrics in its own
+// goroutine, so a stalled destination does not delay reports to the others. Reports to the route
+// which is still busy with the previous one are merged into the next one.
+func (svc *AgentService) sendLoop(ctx context.Context) {
+	svc.mu.Lock()
+	svc.reportTicker = svc.clock.NewTicker(svc.config.GetReportInterval())
+	t := svc.reportTicker
+	svc.mu.Unlock()
+	defer t.Stop()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	pending := make([]chan struct{}, len(svc.routes))
+	for i, rt := range svc.routes {
+		pending[i] = make(chan struct{}, 1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			svc.routeLoop(ctx, rt, pending[i])
+		}()
+	}
+	for {
+		select {
+		case <-t.C():
+			svc.startCycle()
+			for _, p := range pending {
+				select {
+				case p <- struct{}{}:
+				default:
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// routeLoop delivers metrics by the route whenever the report is pending.
+func (svc *AgentService) routeLoop(ctx context.Context, rt *route, pending <-chan struct{}) {
+	for {
+		select {
+		case <-pending:
+			svc.sendRoute(ctx, rt)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// configLoop polls the agent settings from the server with the specified interval.
+func (svc *AgentService) configLoop(ctx context.Context, interval time.Duration) {
+	t := svc.clock.NewTicker(interval)
+	defer t.Stop()
+	for {
+		if err := svc.PollConfig(); err != nil {
+			svc.logger.Error("failed to poll agent settings", slog.String("error", err.Error()))
+		}
+		select {
+		case <-t.C():
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// serveLocal serves the handler on the loopback address or Unix socket until the context is canceled.
+func (svc *AgentService) serveLocal(ctx context.Context, address string, handler http.Handler) error {
+	ln, err := listen(address)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: handler}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
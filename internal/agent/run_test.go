@@ -0,0 +1,219 @@
+package agent
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Jeskay/musthave_metrics/config"
+	dto "github.com/Jeskay/musthave_metrics/internal/Dto"
+)
+
+// fakeClock fires its tickers only when the time is advanced.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+type fakeTicker struct {
+	clock  *fakeClock
+	c      chan time.Time
+	period time.Duration
+	next   time.Time
+	done   bool
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) ticker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTicker{clock: c, c: make(chan time.Time, 1), period: d, next: c.now.Add(d)}
+	c.tickers = append(c.tickers, t)
+	return t
+}
+
+// Advance moves the time forward firing the tickers which are due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	for _, t := range c.tickers {
+		for !t.done && !t.next.After(c.now) {
+			select {
+			case t.c <- t.next:
+			default:
+			}
+			t.next = t.next.Add(t.period)
+		}
+	}
+}
+
+// Tickers returns number of the running tickers.
+func (c *fakeClock) Tickers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, t := range c.tickers {
+		if !t.done {
+			n++
+		}
+	}
+	return n
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.c }
+
+func (t *fakeTicker) Reset(d time.Duration) {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	t.period = d
+	t.next = t.clock.now.Add(d)
+}
+
+func (t *fakeTicker) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	t.done = true
+}
+
+// countingCollector reports a counter incremented by one on every run.
+type countingCollector struct {
+	runs atomic.Int64
+}
+
+func (c *countingCollector) Name() string { return "counting" }
+
+func (c *countingCollector) Interval() time.Duration { return 0 }
+
+func (c *countingCollector) Collect(ctx context.Context) ([]dto.Metrics, error) {
+	c.runs.Add(1)
+	return []dto.Metrics{dto.NewCounterMetrics("Runs", 1)}, nil
+}
+
+func TestRun(t *testing.T) {
+	var requests, received atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ping":
+		case "/updates":
+			requests.Add(1)
+			metrics, ok := decodeMetrics(t, r)
+			if !ok {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			for _, m := range metrics {
+				if m.ID == "Runs" {
+					received.Add(*m.Delta)
+				}
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	conf := &config.AgentConfig{Address: server.URL, RateLimit: 1, PollInterval: 2, ReportInterval: 10, Collectors: []string{"counting"}}
+	svc := NewAgentService(server.Client(), conf, slog.NewTextHandler(os.Stdout, nil))
+	clock := newFakeClock()
+	svc.clock = clock
+	collector := &countingCollector{}
+	svc.Registry().Register(collector)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- svc.Run(ctx)
+	}()
+	require.Eventually(t, func() bool { return clock.Tickers() == 2 }, time.Second, time.Millisecond)
+	require.Eventually(t, func() bool { return collector.runs.Load() == 1 }, time.Second, time.Millisecond)
+
+	for i := int64(2); i <= 4; i++ {
+		clock.Advance(2 * time.Second)
+		require.Eventually(t, func() bool { return collector.runs.Load() == i }, time.Second, time.Millisecond)
+	}
+	assert.Zero(t, requests.Load(), "metrics are sent on report interval")
+
+	clock.Advance(4 * time.Second)
+	require.Eventually(t, func() bool { return received.Load() >= 4 }, time.Second, time.Millisecond)
+
+	cancel()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("agent did not stop")
+	}
+	assert.Equal(t, collector.runs.Load(), received.Load(), "collected metrics are flushed on shutdown")
+	assert.Zero(t, clock.Tickers())
+}
+
+func TestRunListenerError(t *testing.T) {
+	conf := &config.AgentConfig{Address: "localhost:3000", PushAddress: "192.0.2.1:8080"}
+	svc := NewAgentService(http.DefaultClient, conf, slog.NewTextHandler(os.Stdout, nil))
+	svc.clock = newFakeClock()
+	err := svc.Run(context.Background())
+	assert.Error(t, err, "agent stops when local endpoint can not be served")
+}
+
+func TestDeliverOnShutdown(t *testing.T) {
+	release := make(chan struct{})
+	arrived := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- struct{}{}
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	conf := &config.AgentConfig{Address: server.URL}
+	svc := NewAgentService(server.Client(), conf, slog.NewTextHandler(os.Stdout, nil))
+	d := svc.destinations[0]
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, server.URL+"/updates", nil)
+	require.NoError(t, err)
+	done := make(chan error, 1)
+	go func() {
+		done <- svc.deliver(d, req)
+	}()
+	<-arrived
+	cancel()
+	select {
+	case err := <-done:
+		t.Fatal("attempt in flight is interrupted by cancellation:", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	release <- struct{}{}
+	assert.NoError(t, <-done)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, err = http.NewRequestWithContext(ctx, http.MethodPost, server.URL+"/updates", nil)
+	require.NoError(t, err)
+	start := time.Now()
+	assert.Error(t, svc.deliver(d, req))
+	assert.Less(t, time.Since(start), time.Second, "attempt in flight is interrupted by deadline")
+}
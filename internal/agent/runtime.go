@@ -0,0 +1,107 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"runtime/metrics"
+	"sync"
+	"time"
+
+	dto "github.com/Jeskay/musthave_metrics/internal/Dto"
+)
+
+// runtimeQuantiles are reported for every histogram sample.
+var runtimeQuantiles = []float64{0.5, 0.9, 0.99}
+
+// RuntimeCollector reports Go runtime statistics read by runtime/metrics package
+// which, unlike runtime.ReadMemStats, does not stop the world.
+// Cumulative integer samples are reported as counters, other scalar samples as gauges
+// and histograms as gauges of their quantiles with _p50, _p90 and _p99 suffixes.
+// Metric names are derived from the sample names, e.g. /gc/cycles/total:gc-cycles
+// becomes go_gc_cycles_total_gc_cycles.
+type RuntimeCollector struct {
+	interval   time.Duration
+	read       func([]metrics.Sample)
+	mu         sync.Mutex
+	samples    []metrics.Sample
+	names      map[string]string
+	cumulative map[string]bool
+	counters   *cumulativeCounters
+}
+
+// NewRuntimeCollector returns collector of all metrics supported by the Go runtime.
+func NewRuntimeCollector(interval time.Duration) *RuntimeCollector {
+	c := &RuntimeCollector{
+		interval:   interval,
+		read:       metrics.Read,
+		names:      make(map[string]string),
+		cumulative: make(map[string]bool),
+		counters:   newCumulativeCounters(),
+	}
+	for _, d := range metrics.All() {
+		c.samples = append(c.samples, metrics.Sample{Name: d.Name})
+		c.names[d.Name] = "go_" + metricSuffix(d.Name)
+		c.cumulative[d.Name] = d.Cumulative
+	}
+	return c
+}
+
+func (c *RuntimeCollector) Name() string { return "runtime" }
+
+func (c *RuntimeCollector) Interval() time.Duration { return c.interval }
+
+func (c *RuntimeCollector) Collect(ctx context.Context) ([]dto.Metrics, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.read(c.samples)
+	result := make([]dto.Metrics, 0, len(c.samples))
+	for _, s := range c.samples {
+		name := c.names[s.Name]
+		switch s.Value.Kind() {
+		case metrics.KindUint64:
+			if !c.cumulative[s.Name] {
+				result = append(result, dto.NewGaugeMetrics(name, float64(s.Value.Uint64())))
+				continue
+			}
+			if delta, ok := c.counters.Delta(name, s.Value.Uint64()); ok {
+				result = append(result, dto.NewCounterMetrics(name, delta))
+			}
+		case metrics.KindFloat64:
+			result = append(result, dto.NewGaugeMetrics(name, s.Value.Float64()))
+		case metrics.KindFloat64Histogram:
+			h := s.Value.Float64Histogram()
+			for _, q := range runtimeQuantiles {
+				if v, ok := histogramQuantile(h, q); ok {
+					result = append(result, dto.NewGaugeMetrics(fmt.Sprintf("%s_p%g", name, q*100), v))
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+// histogramQuantile estimates the quantile as the upper boundary of the bucket it falls in.
+// Lower boundary is used for the last bucket with infinite upper boundary.
+func histogramQuantile(h *metrics.Float64Histogram, q float64) (float64, bool) {
+	var total uint64
+	for _, count := range h.Counts {
+		total += count
+	}
+	if total == 0 {
+		return 0, false
+	}
+	rank := uint64(math.Ceil(q * float64(total)))
+	var cumulative uint64
+	for i, count := range h.Counts {
+		cumulative += count
+		if cumulative < rank || count == 0 {
+			continue
+		}
+		if upper := h.Buckets[i+1]; !math.IsInf(upper, 1) {
+			return upper, true
+		}
+		return h.Buckets[i], true
+	}
+	return 0, false
+}
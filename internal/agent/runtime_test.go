@@ -0,0 +1,50 @@
+package agent
+
+import (
+	"context"
+	"math"
+	"runtime"
+	"runtime/metrics"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuntimeCollector(t *testing.T) {
+	c := NewRuntimeCollector(0)
+	metrics, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	gauges := gaugeValues(metrics)
+	assert.Positive(t, gauges["go_sched_goroutines_goroutines"])
+	assert.Contains(t, gauges, "go_sched_latencies_seconds_p99")
+	assert.Empty(t, counterValues(metrics), "first reading of cumulative samples is only remembered")
+
+	runtime.GC()
+	metrics, err = c.Collect(context.Background())
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, counterValues(metrics)["go_gc_cycles_total_gc_cycles"], int64(1))
+}
+
+func TestHistogramQuantile(t *testing.T) {
+	h := &metrics.Float64Histogram{
+		Counts:  []uint64{5, 0, 4, 1},
+		Buckets: []float64{math.Inf(-1), 1, 2, 3, math.Inf(1)},
+	}
+	tests := []struct {
+		q    float64
+		want float64
+	}{
+		{0.5, 1},
+		{0.9, 3},
+		{0.99, 3},
+	}
+	for _, tt := range tests {
+		v, ok := histogramQuantile(h, tt.q)
+		require.True(t, ok)
+		assert.Equal(t, tt.want, v, "quantile %v", tt.q)
+	}
+
+	_, ok := histogramQuantile(&metrics.Float64Histogram{Counts: []uint64{0}, Buckets: []float64{0, 1}}, 0.5)
+	assert.False(t, ok)
+}
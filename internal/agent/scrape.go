@@ -0,0 +1,280 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Jeskay/musthave_metrics/config"
+	dto "github.com/Jeskay/musthave_metrics/internal/Dto"
+)
+
+const (
+	scrapeFormatExpvar     = "expvar"
+	scrapeFormatPrometheus = "prometheus"
+)
+
+type scrapeTarget struct {
+	config.ScrapeTarget
+	filter   *nameFilter
+	counters *fractionalCounters
+	last     time.Time
+}
+
+// ScrapeCollector relays metrics of the local applications exposed in expvar JSON
+// or Prometheus text format. Every target is scraped on its own interval if it is
+// longer than the collector's one. Prometheus counters are reported as counters,
+// all the other values are reported as gauges.
+type ScrapeCollector struct {
+	interval time.Duration
+	client   *http.Client
+	mu       sync.Mutex
+	targets  []*scrapeTarget
+	now      func() time.Time
+}
+
+// NewScrapeCollector returns collector of the specified scrape targets.
+func NewScrapeCollector(interval time.Duration, client *http.Client, targets []config.ScrapeTarget) (*ScrapeCollector, error) {
+	c := &ScrapeCollector{
+		interval: interval,
+		client:   client,
+		now:      time.Now,
+	}
+	for _, t := range targets {
+		switch t.Format {
+		case scrapeFormatExpvar, scrapeFormatPrometheus:
+		default:
+			return nil, fmt.Errorf("unknown scrape format %q", t.Format)
+		}
+		filter, err := newNameFilter(t.Allow, t.Deny)
+		if err != nil {
+			return nil, err
+		}
+		c.targets = append(c.targets, &scrapeTarget{
+			ScrapeTarget: t,
+			filter:       filter,
+			counters:     newFractionalCounters(),
+		})
+	}
+	return c, nil
+}
+
+func (c *ScrapeCollector) Name() string { return "scrape" }
+
+func (c *ScrapeCollector) Interval() time.Duration { return c.interval }
+
+func (c *ScrapeCollector) Collect(ctx context.Context) ([]dto.Metrics, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	metrics := make([]dto.Metrics, 0)
+	var errs []error
+	now := c.now()
+	for _, t := range c.targets {
+		if !t.last.IsZero() && now.Sub(t.last) < time.Duration(t.Interval)*time.Second {
+			continue
+		}
+		t.last = now
+		m, err := c.scrape(ctx, t)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("scrape %s: %w", t.URL, err))
+			continue
+		}
+		metrics = append(metrics, m...)
+	}
+	return metrics, errors.Join(errs...)
+}
+
+func (c *ScrapeCollector) scrape(ctx context.Context, t *scrapeTarget) ([]dto.Metrics, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+	var samples []scrapeSample
+	if t.Format == scrapeFormatExpvar {
+		samples, err = parseExpvar(res.Body)
+	} else {
+		samples, err = parsePrometheus(res.Body)
+	}
+	if err != nil {
+		return nil, err
+	}
+	metrics := make([]dto.Metrics, 0, len(samples))
+	for _, s := range samples {
+		if !t.filter.Match(s.name) {
+			continue
+		}
+		name := t.Prefix + s.name
+		if !s.counter {
+			metrics = append(metrics, dto.NewGaugeMetrics(name, s.value))
+			continue
+		}
+		if s.value < 0 {
+			continue
+		}
+		if delta, ok := t.counters.Delta(name, s.value); ok {
+			metrics = append(metrics, dto.NewCounterMetrics(name, delta))
+		}
+	}
+	return metrics, nil
+}
+
+type scrapeSample struct {
+	name    string
+	value   float64
+	counter bool
+}
+
+// parseExpvar flattens numeric values of expvar JSON document.
+// Nested object keys are joined with underscore, arrays are skipped.
+func parseExpvar(r io.Reader) ([]scrapeSample, error) {
+	var vars map[string]any
+	if err := json.NewDecoder(r).Decode(&vars); err != nil {
+		return nil, err
+	}
+	samples := make([]scrapeSample, 0)
+	var walk func(prefix string, v any)
+	walk = func(prefix string, v any) {
+		switch val := v.(type) {
+		case float64:
+			samples = append(samples, scrapeSample{name: metricSuffix(prefix), value: val})
+		case bool:
+			value := 0.0
+			if val {
+				value = 1
+			}
+			samples = append(samples, scrapeSample{name: metricSuffix(prefix), value: value})
+		case map[string]any:
+			for k, nested := range val {
+				if prefix != "" {
+					k = prefix + "_" + k
+				}
+				walk(k, nested)
+			}
+		}
+	}
+	walk("", vars)
+	return samples, nil
+}
+
+// parsePrometheus parses Prometheus text exposition format.
+// Label values are appended to the metric name in the order of label names.
+// Samples with NaN and infinite values are skipped.
+func parsePrometheus(r io.Reader) ([]scrapeSample, error) {
+	types := make(map[string]string)
+	samples := make([]scrapeSample, 0)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			fields := strings.Fields(line)
+			if len(fields) >= 4 && fields[1] == "TYPE" {
+				types[fields[2]] = fields[3]
+			}
+			continue
+		}
+		name, labels, rest, err := splitPrometheusSample(line)
+		if err != nil {
+			return nil, err
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("missing value in line %q", line)
+		}
+		value, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, err
+		}
+		if !isFinite(value) {
+			continue
+		}
+		samples = append(samples, scrapeSample{
+			name:    metricSuffix(name + labels),
+			value:   value,
+			counter: types[name] == "counter",
+		})
+	}
+	return samples, scanner.Err()
+}
+
+func splitPrometheusSample(line string) (name, labels, rest string, err error) {
+	i := strings.IndexAny(line, "{ \t")
+	if i < 0 {
+		return "", "", "", fmt.Errorf("invalid line %q", line)
+	}
+	name = line[:i]
+	if line[i] != '{' {
+		return name, "", line[i:], nil
+	}
+	end := strings.LastIndex(line, "}")
+	if end < i {
+		return "", "", "", fmt.Errorf("invalid labels in line %q", line)
+	}
+	pairs := make(map[string]string)
+	keys := make([]string, 0)
+	for _, pair := range splitLabels(line[i+1 : end]) {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		k = strings.TrimSpace(k)
+		v, err = strconv.Unquote(strings.TrimSpace(v))
+		if err != nil {
+			return "", "", "", fmt.Errorf("invalid label value in line %q", line)
+		}
+		pairs[k] = v
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var sb strings.Builder
+	for _, k := range keys {
+		sb.WriteString("_" + k + "_" + pairs[k])
+	}
+	return name, sb.String(), line[end+1:], nil
+}
+
+// splitLabels splits label pairs by commas which are not inside of quoted values.
+func splitLabels(s string) []string {
+	var (
+		pairs   []string
+		quoted  bool
+		escaped bool
+		start   int
+	)
+	for i, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '"':
+			quoted = !quoted
+		case r == ',' && !quoted:
+			pairs = append(pairs, s[start:i])
+			start = i + 1
+		}
+	}
+	if strings.TrimSpace(s[start:]) != "" {
+		pairs = append(pairs, s[start:])
+	}
+	return pairs
+}
@@ -0,0 +1,156 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Jeskay/musthave_metrics/config"
+	dto "github.com/Jeskay/musthave_metrics/internal/Dto"
+)
+
+func counterValues(metrics []dto.Metrics) map[string]int64 {
+	values := make(map[string]int64)
+	for _, m := range metrics {
+		if m.Delta != nil {
+			values[m.ID] = *m.Delta
+		}
+	}
+	return values
+}
+
+func TestScrapeExpvar(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{
+			"cmdline": ["app", "-v"],
+			"requests": 42,
+			"ready": true,
+			"memstats": {"Alloc": 1024, "NumGC": 3, "PauseNs": [1, 2, 3]}
+		}`)
+	}))
+	defer srv.Close()
+
+	c, err := NewScrapeCollector(0, srv.Client(), []config.ScrapeTarget{{
+		URL:    srv.URL,
+		Format: "expvar",
+		Prefix: "app_",
+		Deny:   []string{"^memstats_NumGC$"},
+	}})
+	require.NoError(t, err)
+	metrics, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{
+		"app_requests":       42,
+		"app_ready":          1,
+		"app_memstats_Alloc": 1024,
+	}, gaugeValues(metrics))
+}
+
+func TestScrapePrometheus(t *testing.T) {
+	total := 10
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `# HELP http_requests_total Handled requests.
+# TYPE http_requests_total counter
+http_requests_total{method="get",code="200"} %d
+# TYPE queue_size gauge
+queue_size 7.5 1700000000000
+# TYPE go_goroutines gauge
+go_goroutines 12
+`, total)
+	}))
+	defer srv.Close()
+
+	c, err := NewScrapeCollector(0, srv.Client(), []config.ScrapeTarget{{
+		URL:    srv.URL,
+		Format: "prometheus",
+		Allow:  []string{"^http_", "^queue_"},
+	}})
+	require.NoError(t, err)
+
+	metrics, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{"queue_size": 7.5}, gaugeValues(metrics))
+	assert.Empty(t, counterValues(metrics), "first counter reading is only remembered")
+
+	total = 25
+	metrics, err = c.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"http_requests_total_code_200_method_get": 15}, counterValues(metrics))
+}
+
+func TestScrapeInterval(t *testing.T) {
+	var calls atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		fmt.Fprint(w, `{"requests": 1}`)
+	}))
+	defer srv.Close()
+
+	c, err := NewScrapeCollector(0, srv.Client(), []config.ScrapeTarget{{URL: srv.URL, Format: "expvar", Interval: 10}})
+	require.NoError(t, err)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	_, err = c.Collect(context.Background())
+	require.NoError(t, err)
+	now = now.Add(5 * time.Second)
+	_, err = c.Collect(context.Background())
+	require.NoError(t, err)
+	now = now.Add(5 * time.Second)
+	_, err = c.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), calls.Load())
+}
+
+func TestScrapeUnknownFormat(t *testing.T) {
+	_, err := NewScrapeCollector(0, http.DefaultClient, []config.ScrapeTarget{{URL: "http://localhost", Format: "xml"}})
+	assert.Error(t, err)
+}
+
+func TestScrapeNonFinite(t *testing.T) {
+	app := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `# TYPE rpc_duration_seconds summary
+rpc_duration_seconds{quantile="0.5"} NaN
+rpc_duration_seconds_sum 0
+# TYPE queue_size gauge
+queue_size +Inf
+queue_depth 3
+`)
+	}))
+	defer app.Close()
+	var delivered atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if metrics, ok := decodeMetrics(t, r); ok {
+			delivered.Store(gaugeValues(metrics))
+		}
+	}))
+	defer server.Close()
+
+	conf := &config.AgentConfig{Address: server.URL, RateLimit: 1}
+	svc := NewAgentService(server.Client(), conf, slog.NewTextHandler(os.Stdout, nil))
+	c, err := NewScrapeCollector(0, app.Client(), []config.ScrapeTarget{{URL: app.URL, Format: "prometheus"}})
+	require.NoError(t, err)
+	svc.Collect(context.Background(), c)
+
+	reqs := make(chan *report, 1)
+	go func() {
+		svc.PrepareMetricsBatch(svc.routes[0], svc.metricNames(svc.routes[0]), reqs, 100, 64<<10)
+		close(reqs)
+	}()
+	svc.SendMetrics(context.Background(), reqs)
+	values, ok := delivered.Load().(map[string]float64)
+	require.True(t, ok, "batch is delivered")
+	assert.Equal(t, 3.0, values["queue_depth"])
+	assert.Equal(t, 0.0, values["rpc_duration_seconds_sum"])
+	assert.NotContains(t, values, "queue_size")
+	assert.NotContains(t, values, "rpc_duration_seconds_quantile_0_5")
+}
@@ -3,39 +3,56 @@
 package agent
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
-	"math/rand/v2"
 	"net/http"
-	"runtime"
 	"sync"
 	"time"
 
-	"github.com/shirou/gopsutil/mem"
-
 	"github.com/Jeskay/musthave_metrics/config"
 	"github.com/Jeskay/musthave_metrics/internal"
 	dto "github.com/Jeskay/musthave_metrics/internal/Dto"
 	"github.com/Jeskay/musthave_metrics/internal/agent/request"
 	"github.com/Jeskay/musthave_metrics/internal/metric/db"
 	"github.com/Jeskay/musthave_metrics/internal/util"
+	"github.com/Jeskay/musthave_metrics/pkg/retry"
 	"github.com/Jeskay/musthave_metrics/pkg/worker"
 )
 
-// AgentService struct provides the functionality of collecting and sending metric data to the server.
+// AgentService struct provides the functionality of collecting and sending metric data to the servers.
 type AgentService struct {
-	workerPool    *worker.WorkerPool[*http.Request]
-	client        *http.Client
-	cipherService *request.Cipher
-	config        *config.AgentConfig
-	JsonAvailable bool
-	storage       internal.Repositories
-	monitorTick   *time.Ticker
-	updateTick    *time.Ticker
-	pollCount     int64
-	serverAddr    string
-	logger        *slog.Logger
+	workerPool   *worker.WorkerPool[*report]
+	client       *http.Client
+	config       *config.AgentConfig
+	storage      internal.Repositories
+	counters     *deltaCounters
+	registry     *Registry
+	relabel      *relabeler
+	aggregate    *aggregator
+	rulesErr     error
+	destinations []*destination
+	routes       []*route
+	stats        *agentStats
+	mu           sync.RWMutex
+	configETag   string
+	clock        clock
+	pollTicker   ticker
+	reportTicker ticker
+	localIP      string
+	logger       *slog.Logger
+}
+
+// report is HTTP request prepared for sending along with the metrics and counter deltas it delivers
+// and the route and destination it is prepared for.
+type report struct {
+	req      *http.Request
+	route    *route
+	dest     *destination
+	metrics  []dto.Metrics
+	counters []dto.Metrics
 }
 
 // NewAgentService function initializes and returns new instance of AgentService.
@@ -43,228 +60,493 @@ func NewAgentService(client *http.Client, conf *config.AgentConfig, logger slog.
 	service := &AgentService{
 		client:     client,
 		storage:    db.NewMemStorage(),
-		serverAddr: "http://" + conf.Address,
+		counters:   newDeltaCounters(),
+		clock:      realClock{},
+		stats:      newAgentStats(),
+		registry:   NewRegistry(),
 		logger:     slog.New(logger),
 		config:     conf,
-		workerPool: worker.NewWorkerPool[*http.Request](conf.RateLimit),
+		workerPool: worker.NewWorkerPool[*report](conf.RateLimit),
+	}
+	service.setupDestinations()
+	relabel, err := newRelabeler(conf.Relabel, relabelVars(conf))
+	if err != nil {
+		service.rulesErr = fmt.Errorf("invalid relabel rules: %w", err)
 	}
-	cipherService, err := request.NewCipher(conf.PublicKey)
+	service.relabel = relabel
+	aggregate, err := newAggregator(conf.Aggregations, conf.GetAggregationMaxSeries())
 	if err != nil {
-		service.logger.Error("failed to initialize cipher service")
-		cipherService = nil
+		service.rulesErr = errors.Join(service.rulesErr, fmt.Errorf("invalid aggregation rules: %w", err))
+	}
+	service.aggregate = aggregate
+	service.registerCollectors()
+	if ip, err := util.OutboundIP(conf.GetDestinations()[0].Address); err == nil {
+		service.localIP = ip.String()
+	} else {
+		service.logger.Error("failed to resolve outbound address", slog.String("error", err.Error()))
 	}
-	service.cipherService = cipherService
 	return service
 }
 
-// CheckAPIAvailability of the metric server and returns error if it is unaccessible.
-func (svc *AgentService) CheckAPIAvailability() error {
-	res, err := http.Get(svc.serverAddr + "/ping")
+// registerCollectors adds built-in collectors to the agent's registry.
+func (svc *AgentService) registerCollectors() {
+	svc.registry.Register(NewMemStatsCollector(0))
+	svc.registry.Register(NewRuntimeCollector(0))
+	svc.registry.Register(NewVirtualMemoryCollector(0))
+	svc.registry.Register(NewCPUCollector(0))
+	svc.registry.Register(NewLoadCollector(0))
+	svc.registry.Register(NewCgroupCollector(0, svc.config.CgroupRoot))
+	if c, err := NewDiskCollector(0, svc.config.DiskInclude, svc.config.DiskExclude); err == nil {
+		svc.registry.Register(c)
+	} else {
+		svc.logger.Error("invalid disk filter", slog.String("error", err.Error()))
+	}
+	if c, err := NewNetworkCollector(0, svc.config.NetInclude, svc.config.NetExclude); err == nil {
+		svc.registry.Register(c)
+	} else {
+		svc.logger.Error("invalid network filter", slog.String("error", err.Error()))
+	}
+	if c, err := NewProcessCollector(0, svc.config.Processes); err == nil {
+		svc.registry.Register(c)
+	} else {
+		svc.logger.Error("invalid process target", slog.String("error", err.Error()))
+	}
+	scrapeClient := &http.Client{Timeout: 5 * time.Second}
+	if c, err := NewScrapeCollector(0, scrapeClient, svc.config.Scrapes); err == nil {
+		svc.registry.Register(c)
+	} else {
+		svc.logger.Error("invalid scrape target", slog.String("error", err.Error()))
+	}
+	if c, err := NewLogTailCollector(0, svc.config.LogTails, svc.config.LogTailState); err == nil {
+		svc.registry.Register(c)
+	} else {
+		svc.logger.Error("invalid log tail target", slog.String("error", err.Error()))
+	}
+}
+
+// ping checks availability of the metric server and returns error if it is unaccessible.
+func (svc *AgentService) ping(d *destination) error {
+	req, err := http.NewRequest(http.MethodGet, d.url+"/ping", nil)
+	if err != nil {
+		return err
+	}
+	svc.writeRealIP(req)
+	res, err := svc.client.Do(req)
 	if res != nil {
 		defer res.Body.Close()
 	}
-	svc.JsonAvailable = (err == nil) && (res.StatusCode == http.StatusOK)
+	if err == nil && res.StatusCode != http.StatusOK {
+		err = &retry.StatusError{Code: res.StatusCode}
+	}
 	return err
 }
 
-// StartMonitoring function initiates the process of collecting memory metrics to store in agent's memory storage.
-func (svc *AgentService) StartMonitoring(interval time.Duration) chan<- struct{} {
-	if svc.monitorTick != nil {
-		return nil
+// breakerChanged logs transition of the circuit breaker and updates agent's own metrics.
+func (svc *AgentService) breakerChanged(d *destination, from, to breakerState) {
+	svc.logger.Warn("circuit breaker state changed",
+		slog.String("destination", d.name),
+		slog.String("from", from.String()),
+		slog.String("to", to.String()),
+	)
+	svc.counters.Add(breakerTransitionsMetric+d.suffix, 1)
+	svc.storeBreakerState(d, to)
+}
+
+func (svc *AgentService) storeBreakerState(d *destination, state breakerState) {
+	if err := svc.storage.SetMany([]dto.Metrics{dto.NewGaugeMetrics(breakerStateMetric+d.suffix, float64(state))}); err != nil {
+		svc.logger.Error(err.Error())
 	}
-	svc.monitorTick = time.NewTicker(interval)
-	quit := make(chan struct{})
-	go func() {
-		mStats := &runtime.MemStats{}
-	loop:
-		for {
-			runtime.ReadMemStats(mStats)
-			svc.CollectMetrics(mStats)
-			select {
-			case t := <-svc.monitorTick.C:
-				svc.logger.Debug(fmt.Sprintf("Tick at %s", t.String()))
-				continue
-			case <-quit:
-				svc.monitorTick.Stop()
-				break loop
-			}
-		}
-	}()
+}
 
-	return quit
+// Registry returns collectors available to the agent.
+// Custom collectors must be registered before monitoring is started.
+func (svc *AgentService) Registry() *Registry {
+	return svc.registry
 }
 
-// StartSending function initiates the process of sending collected data from in-memory storage to the metric server.
-// If JSON format is supported by the API, the agent will send metrics in batches thus saving on the amount of requests.
-func (svc *AgentService) StartSending(interval time.Duration) chan<- struct{} {
-	if svc.updateTick != nil {
-		return nil
-	}
-	quit := make(chan struct{})
-	var finishWg sync.WaitGroup
-	svc.updateTick = time.NewTicker(interval)
-	go func() {
+// send delivers collected metrics by every route concurrently and waits for all of them.
+func (svc *AgentService) send(ctx context.Context) {
+	svc.startCycle()
+	var wg sync.WaitGroup
+	for _, rt := range svc.routes {
+		wg.Add(1)
+		go func() {
+			svc.sendRoute(ctx, rt)
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+}
 
-	loop:
-		for {
-			reqs := make(chan *http.Request, svc.config.RateLimit)
-			finishWg.Add(1)
-			go func() {
-				if svc.JsonAvailable {
-					svc.PrepareMetricsBatch(metricMainList, reqs, 8)
-				} else {
-					svc.PrepareMetrics(metricMainList, reqs)
-				}
-				svc.PrepareMetrics(metricSecondaryList, reqs)
-				close(reqs)
-				finishWg.Done()
-			}()
+// startCycle starts the report: gauges aggregated over the report interval are computed
+// and pending counter deltas are passed to the routes.
+func (svc *AgentService) startCycle() {
+	svc.stats.cycle()
+	svc.flushAggregates()
+	svc.distributeCounters()
+}
 
-			go svc.SendMetrics(reqs)
-			select {
-			case t := <-svc.monitorTick.C:
-				svc.logger.Debug(fmt.Sprintf("Tick at %s", t.String()))
-				continue
-			case <-quit:
-				svc.updateTick.Stop()
-				finishWg.Wait()
-				break loop
+// Collect function runs the collector and saves its metrics to the memory storage of the agent.
+// Counters are accumulated as deltas until they are delivered to the server
+// and gauges matching the aggregation rules are aggregated until the next report.
+// Metrics returned along with an error are saved as well.
+func (svc *AgentService) Collect(ctx context.Context, c Collector) {
+	metrics, err := c.Collect(ctx)
+	svc.stats.collected(c.Name(), err)
+	if err != nil {
+		svc.logger.Error("collector failed", slog.String("collector", c.Name()), slog.String("error", err.Error()))
+	}
+	svc.store(metrics)
+}
 
+func (svc *AgentService) store(metrics []dto.Metrics) {
+	metrics = svc.relabel.Apply(metrics)
+	gauges := make([]dto.Metrics, 0, len(metrics))
+	for _, m := range metrics {
+		if internal.MetricType(m.MType) == internal.CounterMetric {
+			if m.Delta != nil {
+				svc.counters.Add(m.ID, *m.Delta)
 			}
+			continue
+		}
+		if svc.aggregate.Add(m) {
+			continue
 		}
+		gauges = append(gauges, m)
+	}
+	if err := svc.storage.SetMany(gauges); err != nil {
+		svc.logger.Error(err.Error())
+	}
+}
+
+// sendRoute negotiates transport with destinations of the route, replays its outbox
+// and delivers pending metrics to the chosen destination.
+func (svc *AgentService) sendRoute(ctx context.Context, rt *route) {
+	for _, d := range rt.dests {
+		svc.renegotiate(d, svc.config.GetNegotiateInterval())
+		svc.storeBreakerState(d, d.breaker.State())
+	}
+	if d, switched := rt.choose(); switched {
+		svc.logger.Warn("delivery switched to another destination",
+			slog.String("route", rt.name),
+			slog.String("destination", d.name),
+		)
+	}
+	svc.replayOutbox(rt)
+	reqs := make(chan *report, svc.workerPool.Size())
+	go func() {
+		names := svc.metricNames(rt)
+		d := rt.current()
+		if d.currentTransport().batch {
+			count, size := svc.batchLimits(d)
+			svc.PrepareMetricsBatch(rt, names, reqs, count, size)
+		} else {
+			svc.PrepareMetrics(rt, names, reqs)
+		}
+		close(reqs)
 	}()
-	return quit
+	svc.SendMetrics(ctx, reqs)
 }
 
-// CollectMetrics function saves current memory data to the memory storage of the agent.
-func (svc *AgentService) CollectMetrics(mStats *runtime.MemStats) {
-	svc.pollCount++
-	rValue := 1e-307 + rand.Float64()*(1e+308-1e-307)
-	err := svc.storage.SetMany([]dto.Metrics{
-		dto.NewGaugeMetrics("Alloc", float64(mStats.Alloc)),
-		dto.NewGaugeMetrics("BuckHashSys", float64(mStats.BuckHashSys)),
-		dto.NewGaugeMetrics("Frees", float64(mStats.Frees)),
-		dto.NewGaugeMetrics("GCCPUFraction", float64(mStats.GCCPUFraction)),
-		dto.NewGaugeMetrics("GCSys", float64(mStats.GCSys)),
-		dto.NewGaugeMetrics("HeapAlloc", float64(mStats.HeapAlloc)),
-		dto.NewGaugeMetrics("HeapIdle", float64(mStats.HeapIdle)),
-		dto.NewGaugeMetrics("HeapInuse", float64(mStats.HeapInuse)),
-		dto.NewGaugeMetrics("HeapObjects", float64(mStats.HeapObjects)),
-		dto.NewGaugeMetrics("HeapReleased", float64(mStats.HeapReleased)),
-		dto.NewGaugeMetrics("HeapSys", float64(mStats.HeapSys)),
-		dto.NewGaugeMetrics("LastGC", float64(mStats.LastGC)),
-		dto.NewGaugeMetrics("Lookups", float64(mStats.Lookups)),
-		dto.NewGaugeMetrics("MCacheInuse", float64(mStats.MCacheInuse)),
-		dto.NewGaugeMetrics("MCacheSys", float64(mStats.MCacheSys)),
-		dto.NewGaugeMetrics("MSpanInuse", float64(mStats.MSpanInuse)),
-		dto.NewGaugeMetrics("MSpanSys", float64(mStats.MSpanSys)),
-		dto.NewGaugeMetrics("Mallocs", float64(mStats.Mallocs)),
-		dto.NewGaugeMetrics("NextGC", float64(mStats.NextGC)),
-		dto.NewGaugeMetrics("NumForcedGC", float64(mStats.NumForcedGC)),
-		dto.NewGaugeMetrics("NumGC", float64(mStats.NumGC)),
-		dto.NewGaugeMetrics("OtherSys", float64(mStats.OtherSys)),
-		dto.NewGaugeMetrics("PauseTotalNs", float64(mStats.PauseTotalNs)),
-		dto.NewGaugeMetrics("StackInuse", float64(mStats.StackInuse)),
-		dto.NewGaugeMetrics("StackSys", float64(mStats.StackSys)),
-		dto.NewGaugeMetrics("Sys", float64(mStats.Sys)),
-		dto.NewGaugeMetrics("TotalAlloc", float64(mStats.TotalAlloc)),
-		dto.NewCounterMetrics("PollCount", svc.pollCount),
-		dto.NewGaugeMetrics("RandomValue", float64(rValue)),
-	})
+// metricNames returns names of all collected metrics pending delivery by the route.
+func (svc *AgentService) metricNames(rt *route) []string {
+	names := rt.counters.Names()
+	gauges, err := svc.storage.GetAll()
 	if err != nil {
 		svc.logger.Error(err.Error())
 	}
-
-	if v, err := mem.VirtualMemory(); err == nil {
-		err := svc.storage.SetMany([]dto.Metrics{
-			dto.NewGaugeMetrics("TotalMemory", float64(v.Total)),
-			dto.NewGaugeMetrics("FreeMemory", float64(v.Free)),
-			dto.NewGaugeMetrics("CPUutilization1", float64(v.Used)),
-		})
-		if err != nil {
-			svc.logger.Error(err.Error())
-		}
-	} else {
-		svc.logger.Error(err.Error())
+	for _, m := range gauges {
+		names = append(names, m.ID)
 	}
+	return names
 }
 
-// PrepareMetrics function assembles metrics data from agent's storage into HTTP requests to send.
-func (svc *AgentService) PrepareMetrics(metrics []string, requests chan *http.Request) {
+// PrepareMetrics function assembles metrics data from agent's storage into HTTP requests
+// to the current destination of the route.
+// Each metric is sent in JSON format if it is supported by the API and as plain text otherwise.
+func (svc *AgentService) PrepareMetrics(rt *route, metrics []string, requests chan *report) {
+	d := rt.current()
 	var wg sync.WaitGroup
 	for _, metricName := range metrics {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			metric, ok := svc.storage.Get(metricName)
+			metric, ok := svc.takeMetric(rt, metricName)
 			if !ok {
 				return
 			}
-			url := svc.serverAddr + "/update/"
-			rt, err := request.MetricPostPlain(metricName, metric, url)
-			if err != nil {
-				svc.logger.Error(err.Error())
-				return
+			r := &report{route: rt, dest: d, metrics: []dto.Metrics{metric}}
+			if internal.MetricType(metric.MType) == internal.CounterMetric {
+				r.counters = []dto.Metrics{metric}
+			}
+			url := d.url + "/update/"
+			var err error
+			if d.currentTransport().json {
+				r.req, err = request.MetricPostJson(d.hashKey, d.cipher, metric, url)
+			} else {
+				r.req, err = request.MetricPostPlain(metricName, metric, url)
 			}
-			rj, err := request.MetricPostJson(svc.config.HashKey, svc.cipherService, metric, url)
 			if err != nil {
 				svc.logger.Error(err.Error())
+				rt.counters.Restore(r.counters)
 				return
 			}
-			requests <- rt
-			requests <- rj
+			requests <- r
 		}()
 	}
 	wg.Wait()
 }
 
-// PrepareMetricsBatch function assembles metrics data from agent's storage
-// into batch HTTP requests of specified size to send to the server.
-func (svc *AgentService) PrepareMetricsBatch(metrics []string, requests chan *http.Request, batchSize int) {
-	batch := make([]dto.Metrics, 0)
-	url := svc.serverAddr + "/updates"
-	for i, metricName := range metrics {
-		metric, ok := svc.storage.Get(metricName)
+// batchLimits returns the current maximum number of metrics and size of request body
+// of a batch to the destination. Size of the body is measured after compression and encryption.
+func (svc *AgentService) batchLimits(d *destination) (count, size int) {
+	maxCount := svc.config.GetBatchMaxCount()
+	if t := d.currentTransport(); t.maxBatch > 0 {
+		maxCount = min(maxCount, t.maxBatch)
+	}
+	return d.batches.limits(maxCount, svc.config.GetBatchMaxBytes())
+}
+
+// PrepareMetricsBatch function assembles metrics data from agent's storage into batch HTTP requests
+// to the current destination of the route. Batches contain up to maxCount metrics and
+// are split in halves while their request body is larger than maxBytes.
+func (svc *AgentService) PrepareMetricsBatch(rt *route, metrics []string, requests chan *report, maxCount, maxBytes int) {
+	d := rt.current()
+	batch := make([]dto.Metrics, 0, min(maxCount, len(metrics)))
+	for _, metricName := range metrics {
+		metric, ok := svc.takeMetric(rt, metricName)
 		if !ok {
 			continue
 		}
 		batch = append(batch, metric)
-		if (i+1)%batchSize == 0 {
-			r, err := request.MetricsPostJson(svc.config.HashKey, svc.cipherService, batch, url)
-			if err != nil {
-				svc.logger.Error("batch post response failed", slog.String("error", err.Error()))
-				continue
-			}
-			svc.logger.Debug("post metrics batch", slog.Any("response", r))
-			requests <- r
-			batch = make([]dto.Metrics, 0)
+		if len(batch) >= maxCount {
+			svc.prepareBatch(rt, d, batch, requests, maxBytes)
+			batch = make([]dto.Metrics, 0, min(maxCount, len(metrics)))
 		}
 	}
 	if len(batch) > 0 {
-		if r, err := request.MetricsPostJson(svc.config.HashKey, svc.cipherService, batch, url); err == nil {
-			requests <- r
+		svc.prepareBatch(rt, d, batch, requests, maxBytes)
+	}
+}
+
+func (svc *AgentService) prepareBatch(rt *route, d *destination, batch []dto.Metrics, requests chan *report, maxBytes int) {
+	counters := make([]dto.Metrics, 0)
+	for _, m := range batch {
+		if internal.MetricType(m.MType) == internal.CounterMetric {
+			counters = append(counters, m)
 		}
 	}
+	r, err := request.MetricsPostJson(d.hashKey, d.cipher, batch, d.url+"/updates")
+	if err != nil {
+		svc.logger.Error("batch post response failed", slog.String("error", err.Error()))
+		rt.counters.Restore(counters)
+		return
+	}
+	if r.ContentLength > int64(maxBytes) && len(batch) > 1 {
+		half := len(batch) / 2
+		svc.prepareBatch(rt, d, batch[:half], requests, maxBytes)
+		svc.prepareBatch(rt, d, batch[half:], requests, maxBytes)
+		return
+	}
+	svc.logger.Debug("post metrics batch", slog.Any("response", r))
+	requests <- &report{req: r, route: rt, dest: d, metrics: batch, counters: counters}
 }
 
-// SendMetrics function starts sending of the prepared HTTP requests to metric server.
-func (svc *AgentService) SendMetrics(requests chan *http.Request) {
-	svc.workerPool.Run(requests, func(req *http.Request) {
-		err := util.TryRun(func() (err error) {
-			res, err := svc.client.Do(req)
-			if res != nil {
-				defer res.Body.Close()
-			}
-			if err != nil {
-				return
-			}
-			if _, err = io.Copy(io.Discard, res.Body); err != nil {
+// SendMetrics function starts sending of the prepared HTTP requests to metric servers.
+// Counter deltas of the requests which were not acknowledged with successful
+// status are returned to the pending ones of their route to be sent next time.
+// If the outbox is enabled, requests failed due to server outage are queued there instead
+// and all requests are queued while the outbox is not empty to keep them in order.
+// Requests are not sent while the circuit breaker of the destination is open.
+// Requests interrupted by cancellation of the context are postponed as well.
+func (svc *AgentService) SendMetrics(ctx context.Context, requests chan *report) {
+	svc.workerPool.Run(requests, func(r *report) {
+		if r.route.outbox != nil && r.route.outbox.Len() > 0 {
+			svc.spool(r)
+			return
+		}
+		if !r.dest.breaker.Allow() || ctx.Err() != nil {
+			svc.postpone(r)
+			return
+		}
+		svc.writeRealIP(r.req)
+		err := svc.deliver(r.dest, r.req.WithContext(ctx))
+		if err != nil && ctx.Err() != nil {
+			svc.postpone(r)
+			return
+		}
+		svc.stats.sent(err)
+		if err == nil {
+			r.dest.breaker.Success()
+			svc.batchDelivered(r.dest)
+			return
+		}
+		svc.logger.Error(err.Error(), slog.String("destination", r.dest.name))
+		if isTooLarge(err) {
+			svc.batchTooLarge(r.dest)
+		}
+		if !isTransient(err) {
+			r.route.counters.Restore(r.counters)
+			return
+		}
+		r.dest.breaker.Failure()
+		svc.postpone(r)
+	})
+}
+
+// batchTooLarge shrinks batches to the destination which rejected the request as too large.
+func (svc *AgentService) batchTooLarge(d *destination) {
+	if d.batches.tooLarge() {
+		count, size := svc.batchLimits(d)
+		svc.logger.Warn("batches shrunk", slog.String("destination", d.name), slog.Int("count", count), slog.Int("bytes", size))
+	}
+}
+
+// batchDelivered grows batches to the destination back after sustained successful delivery.
+func (svc *AgentService) batchDelivered(d *destination) {
+	if d.batches.success() {
+		count, size := svc.batchLimits(d)
+		svc.logger.Info("batches grown", slog.String("destination", d.name), slog.Int("count", count), slog.Int("bytes", size))
+	}
+}
+
+// postpone keeps metrics of the undelivered request to be sent later,
+// either in the outbox or as pending counter deltas of its route.
+func (svc *AgentService) postpone(r *report) {
+	if r.route.outbox != nil {
+		svc.spool(r)
+		return
+	}
+	r.route.counters.Restore(r.counters)
+}
+
+// deliver sends the request to the destination retrying it on network failures and retryable response statuses.
+// Unsuccessful response status is returned as retry.StatusError.
+// Cancellation of the request context stops retries but does not interrupt the attempt in flight,
+// so metrics acknowledged by the server are not postponed and sent again. The attempt is still
+// interrupted when the deadline of the request context passes.
+func (svc *AgentService) deliver(d *destination, req *http.Request) error {
+	return retry.Do(req.Context(), d.retry, func(ctx context.Context) (err error) {
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
 				return
 			}
-			return
-		}, util.IsConnectionRefused)
+		}
+		actx, cancel := attemptContext(ctx)
+		defer cancel()
+		res, err := svc.client.Do(req.WithContext(actx))
 		if err != nil {
-			svc.logger.Error(err.Error())
+			return
+		}
+		defer res.Body.Close()
+		if _, err = io.Copy(io.Discard, res.Body); err != nil {
+			return
+		}
+		if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusMultipleChoices {
+			return &retry.StatusError{Code: res.StatusCode}
 		}
+		return
+	})
+}
+
+// attemptContext returns context of the delivery attempt which is not canceled with the parent one,
+// but has the same deadline.
+func attemptContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if deadline, ok := ctx.Deadline(); ok {
+		return context.WithDeadline(context.WithoutCancel(ctx), deadline)
+	}
+	return context.WithCancel(context.WithoutCancel(ctx))
+}
+
+// spool queues metrics of the request to the outbox of its route.
+func (svc *AgentService) spool(r *report) {
+	err := r.route.outbox.Push(outboxEntry{
+		Key:     r.req.Header.Get(internal.IdempotencyHeader),
+		Metrics: r.metrics,
 	})
+	if err != nil {
+		svc.logger.Error("failed to queue metrics", slog.String("error", err.Error()))
+		r.route.counters.Restore(r.counters)
+	}
+}
+
+// replayOutbox sends queued batches of the route in order to its current destination if it is available
+// and stores the queue depth and number of dropped batches as agent's own metrics.
+// Batches rejected by the server are dropped.
+func (svc *AgentService) replayOutbox(rt *route) {
+	if rt.outbox == nil {
+		return
+	}
+	d := rt.current()
+	if rt.outbox.Len() > 0 && d.breaker.Allow() && svc.ping(d) == nil {
+		err := rt.outbox.Replay(func(e outboxEntry) error {
+			err := svc.replayBatch(d, e.Key, e.Metrics)
+			switch {
+			case err == nil:
+				d.breaker.Success()
+			case isTransient(err):
+				d.breaker.Failure()
+			default:
+				svc.logger.Error("queued metrics rejected", slog.String("error", err.Error()))
+				svc.counters.Add(outboxDroppedMetric+rt.suffix, 1)
+				return nil
+			}
+			return err
+		})
+		if err != nil {
+			svc.logger.Error("failed to replay queued metrics", slog.String("error", err.Error()))
+		}
+	}
+	depth := dto.NewGaugeMetrics(outboxDepthMetric+rt.suffix, float64(rt.outbox.Len()))
+	if err := svc.storage.SetMany([]dto.Metrics{depth}); err != nil {
+		svc.logger.Error(err.Error())
+	}
+	if dropped := rt.outbox.TakeDropped(); dropped > 0 {
+		svc.counters.Add(outboxDroppedMetric+rt.suffix, dropped)
+	}
+}
+
+// replayBatch sends queued batch to the destination. Batch rejected as too large is split in halves
+// delivered with idempotency keys derived from the key of the batch.
+func (svc *AgentService) replayBatch(d *destination, key string, metrics []dto.Metrics) error {
+	req, err := request.MetricsPostJson(d.hashKey, d.cipher, metrics, d.url+"/updates")
+	if err != nil {
+		return err
+	}
+	if key != "" {
+		req.Header.Set(internal.IdempotencyHeader, key)
+	}
+	svc.writeRealIP(req)
+	err = svc.deliver(d, req)
+	svc.stats.sent(err)
+	if !isTooLarge(err) || len(metrics) < 2 {
+		return err
+	}
+	svc.batchTooLarge(d)
+	half := len(metrics) / 2
+	for i, part := range [][]dto.Metrics{metrics[:half], metrics[half:]} {
+		partKey := key
+		if key != "" {
+			partKey = fmt.Sprintf("%s-%d", key, i)
+		}
+		if err := svc.replayBatch(d, partKey, part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// takeMetric returns metric value to send by the route. Pending counter delta is reset
+// and has to be restored if it is not delivered.
+func (svc *AgentService) takeMetric(rt *route, name string) (dto.Metrics, bool) {
+	if m, ok := rt.counters.Take(name); ok {
+		return m, ok
+	}
+	return svc.storage.Get(name)
+}
+
+func (svc *AgentService) writeRealIP(req *http.Request) {
+	if svc.localIP != "" {
+		req.Header.Set(internal.RealIPHeader, svc.localIP)
+	}
 }
@@ -1,12 +1,17 @@
 package agent
 
 import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
 	"log/slog"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"runtime"
-	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -26,7 +31,9 @@ func TestCollectMetrics(t *testing.T) {
 	}
 	conf := &config.AgentConfig{Address: "localhost:3000"}
 	svc := NewAgentService(http.DefaultClient, conf, slog.NewTextHandler(os.Stdout, nil))
-	svc.CollectMetrics(mStats)
+	collector := NewMemStatsCollector(0)
+	collector.read = func(m *runtime.MemStats) { *m = *mStats }
+	svc.Collect(context.Background(), collector)
 	m, _ := svc.storage.Get("Alloc")
 	assert.Equal(t, string(internal.GaugeMetric), m.MType)
 	require.True(t, m.Value != nil)
@@ -36,15 +43,13 @@ func TestCollectMetrics(t *testing.T) {
 	assert.False(t, ok)
 
 	var i int64
-	var sum int64 = 0
 	for i = 1; i < 100; i++ {
-		sum += i
-		m, ok = svc.storage.Get("PollCount")
+		m, ok = svc.counters.Get("PollCount")
 		assert.True(t, ok)
 		assert.Equal(t, string(internal.CounterMetric), m.MType)
 		require.True(t, m.Delta != nil)
-		assert.Equal(t, sum, *m.Delta)
-		svc.CollectMetrics(mStats)
+		assert.Equal(t, i, *m.Delta)
+		svc.Collect(context.Background(), collector)
 	}
 
 	m, ok = svc.storage.Get("RandomValue")
@@ -68,29 +73,140 @@ func TestPrepareMetrics(t *testing.T) {
 		GCCPUFraction: 10.3333,
 		HeapSys:       0,
 	}
-	reqs := make(chan *http.Request)
+	reqs := make(chan *report)
 	conf := &config.AgentConfig{Address: "localhost:3000"}
 	svc := NewAgentService(http.DefaultClient, conf, slog.NewTextHandler(os.Stdout, nil))
 	svc.storage.Set(dto.NewGaugeMetrics("Alloc", float64(mStats.Alloc)))
 	svc.storage.Set(dto.NewGaugeMetrics("HeapIdle", float64(mStats.HeapIdle)))
 	svc.storage.Set(dto.NewGaugeMetrics("Frees", float64(mStats.Frees)))
-	svc.storage.Set(dto.NewCounterMetrics("PollCount", int64(1)))
+	svc.counters.Add("PollCount", 1)
 	svc.storage.Set(dto.NewGaugeMetrics("GCCPUFraction", mStats.GCCPUFraction))
 	svc.storage.Set(dto.NewGaugeMetrics("HeapSys", float64(mStats.HeapSys)))
-	go svc.PrepareMetrics(metricMainList, reqs)
+	svc.destinations[0].transport = transport{}
+	go func() {
+		svc.PrepareMetrics(svc.routes[0], svc.metricNames(svc.routes[0]), reqs)
+		close(reqs)
+	}()
 	count := 0
+	for r := range reqs {
+		assert.Equal(t, http.MethodPost, r.req.Method)
+		assert.Contains(t, expected, r.req.URL.String())
+		count++
+	}
+	assert.Equal(t, len(expected), count)
+
+	svc.destinations[0].transport = transport{json: true}
+	svc.counters.Add("PollCount", 1)
+	reqs = make(chan *report)
+	go func() {
+		svc.PrepareMetrics(svc.routes[0], svc.metricNames(svc.routes[0]), reqs)
+		close(reqs)
+	}()
 	jsonCount := 0
 	for r := range reqs {
-		assert.Equal(t, http.MethodPost, r.Method)
-		req := r.URL.String()
-		if strings.Contains(r.Header.Get("Content-Type"), "application/json") {
-			assert.Equal(t, "http://localhost:3000/update/", req)
-			jsonCount++
-		} else {
-			assert.Contains(t, expected, req)
-			count++
-		}
+		assert.Equal(t, "http://localhost:3000/update/", r.req.URL.String())
+		assert.Contains(t, r.req.Header.Get("Content-Type"), "application/json")
+		jsonCount++
 	}
 	assert.Equal(t, len(expected), jsonCount)
-	assert.Equal(t, len(expected), count)
+}
+
+// decodeMetrics decodes gzipped batch of metrics from the request body.
+// Failures are reported with assert, so it can be called from the server goroutine.
+func decodeMetrics(t *testing.T, r *http.Request) ([]dto.Metrics, bool) {
+	var metrics []dto.Metrics
+	gz, err := gzip.NewReader(r.Body)
+	if !assert.NoError(t, err) {
+		return nil, false
+	}
+	return metrics, assert.NoError(t, json.NewDecoder(gz).Decode(&metrics))
+}
+
+func TestSendMetricsRestoresCounters(t *testing.T) {
+	var failing atomic.Bool
+	var received atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		metrics, ok := decodeMetrics(t, r)
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		for _, m := range metrics {
+			if m.ID == "PollCount" && m.Delta != nil {
+				received.Add(*m.Delta)
+			}
+		}
+	}))
+	defer server.Close()
+
+	conf := &config.AgentConfig{Address: server.URL, RateLimit: 1}
+	svc := NewAgentService(server.Client(), conf, slog.NewTextHandler(os.Stdout, nil))
+	send := func() {
+		reqs := make(chan *report, 1)
+		go func() {
+			svc.PrepareMetricsBatch(svc.routes[0], []string{"PollCount"}, reqs, 8, 64<<10)
+			close(reqs)
+		}()
+		svc.SendMetrics(context.Background(), reqs)
+	}
+
+	failing.Store(true)
+	for i := 0; i < 3; i++ {
+		svc.counters.Add("PollCount", 1)
+		send()
+	}
+	m, ok := svc.counters.Get("PollCount")
+	require.True(t, ok)
+	assert.Equal(t, int64(3), *m.Delta)
+	assert.Zero(t, received.Load())
+
+	failing.Store(false)
+	svc.counters.Add("PollCount", 2)
+	send()
+	assert.Equal(t, int64(5), received.Load())
+	_, ok = svc.counters.Get("PollCount")
+	assert.False(t, ok)
+
+	send()
+	assert.Equal(t, int64(5), received.Load())
+}
+
+type stubCollector struct {
+	metrics []dto.Metrics
+}
+
+func (c *stubCollector) Name() string { return "stub" }
+
+func (c *stubCollector) Interval() time.Duration { return 0 }
+
+func (c *stubCollector) Collect(ctx context.Context) ([]dto.Metrics, error) {
+	return c.metrics, nil
+}
+
+func TestRegistryCollectors(t *testing.T) {
+	conf := &config.AgentConfig{Address: "localhost:3000", Collectors: []string{"stub", "virtual_memory"}}
+	svc := NewAgentService(http.DefaultClient, conf, slog.NewTextHandler(os.Stdout, nil))
+	svc.Registry().Register(&stubCollector{metrics: []dto.Metrics{
+		dto.NewGaugeMetrics("Custom", 1.5),
+		dto.NewCounterMetrics("Hits", 2),
+	}})
+
+	collectors, err := svc.Registry().Enabled(conf.EnabledCollectors())
+	require.NoError(t, err)
+	require.Len(t, collectors, 2)
+	assert.Equal(t, "stub", collectors[0].Name())
+
+	_, err = svc.Registry().Enabled([]string{"unknown"})
+	assert.Error(t, err)
+
+	svc.Collect(context.Background(), collectors[0])
+	svc.Collect(context.Background(), collectors[0])
+	assert.ElementsMatch(t, []string{"Custom", "Hits"}, svc.metricNames(svc.routes[0]))
+	m, ok := svc.counters.Get("Hits")
+	require.True(t, ok)
+	assert.Equal(t, int64(4), *m.Delta)
 }
@@ -0,0 +1,234 @@
+package agent
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Jeskay/musthave_metrics/config"
+	dto "github.com/Jeskay/musthave_metrics/internal/Dto"
+)
+
+// stalledIntervals is the number of intervals without collection or sending after which the agent is unhealthy.
+const stalledIntervals = 3
+
+// agentStats records the activity of the agent reported by the status endpoint.
+type agentStats struct {
+	mu            sync.Mutex
+	now           func() time.Time
+	startedAt     time.Time
+	lastCollect   time.Time
+	lastSendCycle time.Time
+	lastSend      time.Time
+	collectErrors map[string]int64
+	sendErrors    int64
+	lastError     string
+}
+
+func newAgentStats() *agentStats {
+	s := &agentStats{now: time.Now, collectErrors: make(map[string]int64)}
+	s.startedAt = s.now()
+	return s
+}
+
+// collected records run of the collector.
+func (s *agentStats) collected(name string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastCollect = s.now()
+	if err != nil {
+		s.collectErrors[name]++
+		s.lastError = err.Error()
+	}
+}
+
+// cycle records start of the sending cycle.
+func (s *agentStats) cycle() {
+	s.mu.Lock()
+	s.lastSendCycle = s.now()
+	s.mu.Unlock()
+}
+
+// sent records result of the delivery to the server.
+func (s *agentStats) sent(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		s.lastSend = s.now()
+		return
+	}
+	s.sendErrors++
+	s.lastError = err.Error()
+}
+
+// stalled returns true if collection or sending has not run for longer than the limit.
+// Activity is counted from the start of the agent until the first run.
+func (s *agentStats) stalled(limit time.Duration) bool {
+	if limit <= 0 {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for _, t := range []time.Time{s.lastCollect, s.lastSendCycle} {
+		if t.IsZero() {
+			t = s.startedAt
+		}
+		if now.Sub(t) > limit {
+			return true
+		}
+	}
+	return false
+}
+
+// AgentStatus is the state of the agent served by the status endpoint.
+type AgentStatus struct {
+	Healthy       bool               `json:"healthy"`
+	StartedAt     time.Time          `json:"started_at"`
+	LastCollect   *time.Time         `json:"last_collect,omitempty"`
+	LastSend      *time.Time         `json:"last_send,omitempty"`
+	CollectErrors map[string]int64   `json:"collect_errors"`
+	SendErrors    int64              `json:"send_errors"`
+	LastError     string             `json:"last_error,omitempty"`
+	Routes        []RouteStatus      `json:"routes"`
+	Config        config.AgentConfig `json:"config"`
+}
+
+// RouteStatus describes delivery of metrics by the route.
+type RouteStatus struct {
+	Name            string              `json:"name"`
+	Mode            string              `json:"mode"`
+	Current         string              `json:"current"`
+	OutboxDepth     int                 `json:"outbox_depth"`
+	PendingCounters int                 `json:"pending_counters"`
+	Destinations    []DestinationStatus `json:"destinations"`
+}
+
+// DestinationStatus describes the metric server and the transport negotiated with it.
+type DestinationStatus struct {
+	Name         string            `json:"name"`
+	URL          string            `json:"url"`
+	Breaker      string            `json:"breaker"`
+	Capabilities *dto.Capabilities `json:"capabilities,omitempty"`
+	Transport    TransportStatus   `json:"transport"`
+	// Current limits of the batches, shrunk if the server rejects too large ones.
+	BatchCount int `json:"batch_count"`
+	BatchBytes int `json:"batch_bytes"`
+}
+
+// TransportStatus describes the transport of metrics negotiated with the server.
+type TransportStatus struct {
+	JSON     bool `json:"json"`
+	Batch    bool `json:"batch"`
+	MaxBatch int  `json:"max_batch"`
+}
+
+func timeRef(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// Status returns the current state of the agent with secrets of its configuration redacted.
+func (svc *AgentService) Status() AgentStatus {
+	svc.mu.RLock()
+	conf := svc.config.Redacted()
+	svc.mu.RUnlock()
+
+	st := AgentStatus{
+		Healthy: svc.Healthy(),
+		Routes:  make([]RouteStatus, 0, len(svc.routes)),
+		Config:  conf,
+	}
+	for _, rt := range svc.routes {
+		rs := RouteStatus{
+			Name:            rt.name,
+			Mode:            rt.mode,
+			Current:         rt.current().name,
+			PendingCounters: len(rt.counters.Names()),
+			Destinations:    make([]DestinationStatus, 0, len(rt.dests)),
+		}
+		if rt.outbox != nil {
+			rs.OutboxDepth = rt.outbox.Len()
+		}
+		for _, d := range rt.dests {
+			count, size := svc.batchLimits(d)
+			d.mu.RLock()
+			t, caps := d.transport, d.capabilities
+			d.mu.RUnlock()
+			rs.Destinations = append(rs.Destinations, DestinationStatus{
+				Name:         d.name,
+				URL:          d.url,
+				Breaker:      d.breaker.State().String(),
+				Capabilities: caps,
+				Transport:    TransportStatus{JSON: t.json, Batch: t.batch, MaxBatch: t.maxBatch},
+				BatchCount:   count,
+				BatchBytes:   size,
+			})
+		}
+		st.Routes = append(st.Routes, rs)
+	}
+	svc.stats.mu.Lock()
+	st.StartedAt = svc.stats.startedAt
+	st.LastCollect = timeRef(svc.stats.lastCollect)
+	st.LastSend = timeRef(svc.stats.lastSend)
+	st.CollectErrors = make(map[string]int64, len(svc.stats.collectErrors))
+	for name, n := range svc.stats.collectErrors {
+		st.CollectErrors[name] = n
+	}
+	st.SendErrors = svc.stats.sendErrors
+	st.LastError = svc.stats.lastError
+	svc.stats.mu.Unlock()
+	return st
+}
+
+// Healthy reports whether the agent keeps collecting and sending metrics.
+// Failures to deliver metrics to the server do not make the agent unhealthy.
+func (svc *AgentService) Healthy() bool {
+	svc.mu.RLock()
+	interval := max(svc.config.GetReportInterval(), svc.config.GetPollInterval())
+	svc.mu.RUnlock()
+	return !svc.stats.stalled(stalledIntervals * interval)
+}
+
+// StatusHandler returns handler of the local status endpoints.
+//
+//	GET /healthz  responds with 200 OK if the agent is healthy and 503 otherwise
+//	GET /status   returns state of the agent
+//	GET /metrics  returns collected gauges and counter deltas not sent yet
+func (svc *AgentService) StatusHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		if !svc.Healthy() {
+			http.Error(w, "stalled", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(svc.Status())
+	})
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		metrics, err := svc.storage.GetAll()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		for _, name := range svc.counters.Names() {
+			if m, ok := svc.counters.Get(name); ok {
+				metrics = append(metrics, m)
+			}
+		}
+		slices.SortFunc(metrics, func(a, b dto.Metrics) int {
+			return strings.Compare(a.ID, b.ID)
+		})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(metrics)
+	})
+	return mux
+}
@@ -0,0 +1,89 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Jeskay/musthave_metrics/config"
+	dto "github.com/Jeskay/musthave_metrics/internal/Dto"
+)
+
+func TestStatusHandler(t *testing.T) {
+	conf := &config.AgentConfig{
+		Address:        "localhost:3000",
+		HashKey:        "secret",
+		PublicKey:      "/etc/agent/public.pem",
+		TLSKey:         "/etc/agent/tls.key",
+		ReportInterval: 10,
+		PollInterval:   2,
+	}
+	svc := NewAgentService(http.DefaultClient, conf, slog.NewTextHandler(os.Stdout, nil))
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	svc.stats.now = func() time.Time { return now }
+	svc.stats.startedAt = now
+	handler := svc.StatusHandler()
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	svc.Collect(context.Background(), &stubCollector{metrics: []dto.Metrics{
+		dto.NewGaugeMetrics("Custom", 1.5),
+		dto.NewCounterMetrics("Hits", 2),
+	}})
+	svc.stats.collected("cpu", errors.New("no cpu"))
+	svc.stats.cycle()
+	svc.stats.sent(nil)
+	assert.Equal(t, http.StatusOK, get("/healthz").Code)
+
+	var st AgentStatus
+	w := get("/status")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&st))
+	assert.True(t, st.Healthy)
+	assert.Equal(t, map[string]int64{"cpu": 1}, st.CollectErrors)
+	assert.Equal(t, "no cpu", st.LastError)
+	require.NotNil(t, st.LastSend)
+	assert.Equal(t, now, *st.LastSend)
+	require.Len(t, st.Routes, 1)
+	assert.Equal(t, 1, st.Routes[0].PendingCounters)
+	require.Len(t, st.Routes[0].Destinations, 1)
+	assert.Equal(t, "closed", st.Routes[0].Destinations[0].Breaker)
+	assert.Equal(t, "[redacted]", st.Config.HashKey)
+	assert.Equal(t, "[redacted]", st.Config.PublicKey)
+	assert.Equal(t, "[redacted]", st.Config.TLSKey)
+	assert.Empty(t, st.Config.TLSCert)
+	assert.Equal(t, "secret", conf.HashKey, "configuration of the agent is not changed")
+
+	var metrics []dto.Metrics
+	w = get("/metrics")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&metrics))
+	require.Len(t, metrics, 2)
+	assert.Equal(t, "Custom", metrics[0].ID)
+	assert.Equal(t, int64(2), *metrics[1].Delta)
+
+	now = now.Add(time.Minute)
+	svc.stats.collected("stub", nil)
+	assert.Equal(t, http.StatusServiceUnavailable, get("/healthz").Code, "sending has stalled")
+}
+
+func TestRunStatusAddressNotLoopback(t *testing.T) {
+	conf := &config.AgentConfig{Address: "localhost:3000", StatusAddress: "0.0.0.0:0"}
+	svc := NewAgentService(http.DefaultClient, conf, slog.NewTextHandler(os.Stdout, nil))
+	svc.clock = newFakeClock()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	assert.ErrorContains(t, svc.Run(ctx), "loopback", "status endpoints are served only locally")
+}
@@ -0,0 +1,112 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	dto "github.com/Jeskay/musthave_metrics/internal/Dto"
+	"github.com/Jeskay/musthave_metrics/pkg/retry"
+)
+
+// transport is the way metrics are delivered to the server, chosen from its capabilities.
+// Metrics are sent in JSON batches, as single JSON metrics or in plain text in order of preference.
+type transport struct {
+	json  bool
+	batch bool
+	// maxBatch is the maximum number of metrics in a batch accepted by the server, unlimited when zero.
+	maxBatch int
+}
+
+// legacyTransport is used before the transport is negotiated and with available servers
+// which do not report their capabilities, so counters are sent in batches with idempotency keys.
+var legacyTransport = transport{json: true, batch: true}
+
+func chooseTransport(caps dto.Capabilities) transport {
+	t := transport{maxBatch: caps.MaxBatchSize}
+	t.json = slices.Contains(caps.Encodings, dto.EncodingJSON) && slices.Contains(caps.Compression, dto.CompressionGzip)
+	t.batch = t.json && caps.Batch
+	return t
+}
+
+// Negotiate requests capabilities of every metric server and chooses the transport of metrics to it.
+func (svc *AgentService) Negotiate() error {
+	errs := make([]error, 0, len(svc.destinations))
+	for _, d := range svc.destinations {
+		if err := svc.negotiate(d); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", d.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// negotiate requests capabilities of the server and chooses the transport of metrics.
+// Servers without capabilities endpoint are treated as supporting JSON batches if they respond to ping.
+// The current transport is kept if the server is unavailable.
+func (svc *AgentService) negotiate(d *destination) error {
+	req, err := http.NewRequest(http.MethodGet, d.url+"/capabilities", nil)
+	if err != nil {
+		return err
+	}
+	svc.writeRealIP(req)
+	res, err := svc.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	var (
+		t    transport
+		caps *dto.Capabilities
+	)
+	switch res.StatusCode {
+	case http.StatusOK:
+		caps = &dto.Capabilities{}
+		if err := json.NewDecoder(res.Body).Decode(caps); err != nil {
+			return err
+		}
+		if caps.APIVersion != dto.APIVersion {
+			svc.logger.Warn("unknown server API version", slog.String("destination", d.name), slog.String("version", caps.APIVersion))
+		}
+		if d.cipher != nil && !slices.Contains(caps.Encryption, dto.EncryptionRSAOAEP) {
+			svc.logger.Warn("server does not support encryption of metrics", slog.String("destination", d.name))
+		}
+		t = chooseTransport(*caps)
+	case http.StatusNotFound:
+		if err := svc.ping(d); err != nil {
+			return err
+		}
+		t = legacyTransport
+	default:
+		return &retry.StatusError{Code: res.StatusCode}
+	}
+	d.mu.Lock()
+	d.transport = t
+	d.capabilities = caps
+	d.negotiatedAt = time.Now()
+	d.mu.Unlock()
+	svc.logger.Debug("negotiated transport",
+		slog.String("destination", d.name),
+		slog.Bool("json", t.json),
+		slog.Bool("batch", t.batch),
+		slog.Int("max_batch", t.maxBatch),
+	)
+	return nil
+}
+
+// renegotiate chooses transport to the server again when the previous choice is older than the interval.
+func (svc *AgentService) renegotiate(d *destination, interval time.Duration) {
+	d.mu.RLock()
+	stale := time.Since(d.negotiatedAt) >= interval
+	d.mu.RUnlock()
+	if !stale || d.breaker.State() != breakerClosed {
+		return
+	}
+	if err := svc.negotiate(d); err != nil {
+		svc.logger.Error("failed to negotiate transport", slog.String("destination", d.name), slog.String("error", err.Error()))
+	}
+}
@@ -0,0 +1,123 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Jeskay/musthave_metrics/config"
+	"github.com/Jeskay/musthave_metrics/internal"
+	dto "github.com/Jeskay/musthave_metrics/internal/Dto"
+)
+
+func TestChooseTransport(t *testing.T) {
+	tests := []struct {
+		name string
+		caps dto.Capabilities
+		want transport
+	}{
+		{
+			"batches",
+			dto.Capabilities{Encodings: []string{"plain", "json"}, Compression: []string{"gzip"}, Batch: true},
+			transport{json: true, batch: true},
+		},
+		{
+			"small batches",
+			dto.Capabilities{Encodings: []string{"json"}, Compression: []string{"gzip"}, Batch: true, MaxBatchSize: 3},
+			transport{json: true, batch: true, maxBatch: 3},
+		},
+		{
+			"single json",
+			dto.Capabilities{Encodings: []string{"plain", "json"}, Compression: []string{"gzip"}},
+			transport{json: true},
+		},
+		{
+			"plain without gzip",
+			dto.Capabilities{Encodings: []string{"plain", "json"}, Batch: true},
+			transport{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, chooseTransport(tt.caps))
+		})
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	caps := &dto.Capabilities{
+		APIVersion:   dto.APIVersion,
+		Encodings:    []string{dto.EncodingPlain, dto.EncodingJSON},
+		Compression:  []string{dto.CompressionGzip},
+		Batch:        true,
+		MaxBatchSize: 5,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/ping":
+		case r.URL.Path == "/capabilities" && caps != nil:
+			json.NewEncoder(w).Encode(caps)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	conf := &config.AgentConfig{Address: server.URL}
+	svc := NewAgentService(server.Client(), conf, slog.NewTextHandler(os.Stdout, nil))
+	assert.Equal(t, legacyTransport, svc.destinations[0].currentTransport(), "JSON batches are used before negotiation")
+
+	require.NoError(t, svc.Negotiate())
+	assert.Equal(t, transport{json: true, batch: true, maxBatch: 5}, svc.destinations[0].currentTransport())
+
+	caps = nil
+	require.NoError(t, svc.Negotiate())
+	assert.Equal(t, legacyTransport, svc.destinations[0].currentTransport(), "servers without capabilities endpoint get JSON batches")
+}
+
+func TestTransportServerDownAtStart(t *testing.T) {
+	var (
+		down     atomic.Bool
+		mu       sync.Mutex
+		requests []string
+	)
+	down.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			mu.Lock()
+			requests = append(requests, r.URL.Path)
+			assert.NotEmpty(t, r.Header.Get(internal.IdempotencyHeader), "request to %s has idempotency key", r.URL.Path)
+			mu.Unlock()
+		}
+		if down.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	conf := &config.AgentConfig{Address: server.URL, RateLimit: 1, OutboxDir: t.TempDir()}
+	svc := NewAgentService(server.Client(), conf, slog.NewTextHandler(os.Stdout, nil))
+	svc.counters.Add("PollCount", 1)
+	svc.storage.Set(dto.NewGaugeMetrics("Alloc", 1))
+	svc.sendRoute(context.Background(), svc.routes[0])
+	assert.Equal(t, 1, svc.routes[0].outbox.Len())
+
+	down.Store(false)
+	svc.sendRoute(context.Background(), svc.routes[0])
+	assert.Zero(t, svc.routes[0].outbox.Len())
+	mu.Lock()
+	defer mu.Unlock()
+	require.NotEmpty(t, requests)
+	for _, path := range requests {
+		assert.Equal(t, "/updates", path, "metrics are sent in batches before negotiation")
+	}
+}
@@ -0,0 +1,90 @@
+package metric
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	dto "github.com/Jeskay/musthave_metrics/internal/Dto"
+)
+
+// AgentConfigDocument holds settings of the agents: default ones, per group and per agent.
+type AgentConfigDocument struct {
+	Default dto.AgentSettings            `json:"default"`
+	Groups  map[string]dto.AgentSettings `json:"groups"`
+	Agents  map[string]dto.AgentSettings `json:"agents"`
+}
+
+// AgentConfigStore serves settings of the agents from JSON document on disk.
+// The document is loaded again whenever the file is modified.
+type AgentConfigStore struct {
+	path    string
+	mu      sync.Mutex
+	doc     AgentConfigDocument
+	modTime time.Time
+}
+
+// NewAgentConfigStore loads agent settings from the file.
+func NewAgentConfigStore(path string) (*AgentConfigStore, error) {
+	s := &AgentConfigStore{path: path}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Resolve returns settings of the agent along with their ETag. Agent settings
+// override settings of its group which override the default ones.
+// Previously loaded document is used when reload fails.
+func (s *AgentConfigStore) Resolve(id, group string) (dto.AgentSettings, string, error) {
+	s.mu.Lock()
+	if err := s.reload(); err != nil {
+		s.mu.Unlock()
+		return dto.AgentSettings{}, "", err
+	}
+	settings := s.doc.Default
+	if g, ok := s.doc.Groups[group]; ok && group != "" {
+		settings = settings.Overlay(g)
+	}
+	if a, ok := s.doc.Agents[id]; ok && id != "" {
+		settings = settings.Overlay(a)
+	}
+	s.mu.Unlock()
+
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return dto.AgentSettings{}, "", err
+	}
+	sum := sha256.Sum256(data)
+	return settings, `"` + hex.EncodeToString(sum[:16]) + `"`, nil
+}
+
+func (s *AgentConfigStore) reload() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		if !s.modTime.IsZero() {
+			return nil
+		}
+		return err
+	}
+	if !info.ModTime().After(s.modTime) {
+		return nil
+	}
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	var doc AgentConfigDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		if !s.modTime.IsZero() {
+			return nil
+		}
+		return err
+	}
+	s.doc = doc
+	s.modTime = info.ModTime()
+	return nil
+}
@@ -0,0 +1,50 @@
+package metric
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAgentConfigStoreResolve(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agents.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"default": {"report_interval": 10, "poll_interval": 2, "rate_limit": 1},
+		"groups": {"web": {"report_interval": 5}},
+		"agents": {"host-1": {"rate_limit": 4}}
+	}`), 0o644))
+	store, err := NewAgentConfigStore(path)
+	require.NoError(t, err)
+
+	settings, etag, err := store.Resolve("host-1", "web")
+	require.NoError(t, err)
+	assert.Equal(t, 5, *settings.ReportInterval)
+	assert.Equal(t, 2, *settings.PollInterval)
+	assert.Equal(t, 4, *settings.RateLimit)
+
+	_, defaultTag, err := store.Resolve("host-2", "")
+	require.NoError(t, err)
+	assert.NotEqual(t, etag, defaultTag)
+	_, sameTag, err := store.Resolve("host-1", "web")
+	require.NoError(t, err)
+	assert.Equal(t, etag, sameTag)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"default": {"report_interval": 20}}`), 0o644))
+	later := time.Now().Add(time.Second)
+	require.NoError(t, os.Chtimes(path, later, later))
+	settings, _, err = store.Resolve("host-1", "web")
+	require.NoError(t, err)
+	assert.Equal(t, 20, *settings.ReportInterval)
+	assert.Nil(t, settings.RateLimit)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{`), 0o644))
+	later = later.Add(time.Second)
+	require.NoError(t, os.Chtimes(path, later, later))
+	settings, _, err = store.Resolve("host-1", "web")
+	require.NoError(t, err, "previous document is kept when file is invalid")
+	assert.Equal(t, 20, *settings.ReportInterval)
+}
@@ -0,0 +1,140 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/Jeskay/musthave_metrics/pkg/retry"
+)
+
+type appliedRequest struct {
+	status  int
+	body    []byte
+	expires time.Time
+}
+
+// MemIdempotencyStore keeps responses of applied requests in memory for the specified time.
+type MemIdempotencyStore struct {
+	ttl  time.Duration
+	mu   sync.Mutex
+	data map[string]appliedRequest
+}
+
+func NewMemIdempotencyStore(ttl time.Duration) *MemIdempotencyStore {
+	return &MemIdempotencyStore{
+		ttl:  ttl,
+		data: make(map[string]appliedRequest),
+	}
+}
+
+func (ms *MemIdempotencyStore) Get(key string) (int, []byte, bool) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	r, ok := ms.data[key]
+	if !ok || time.Now().After(r.expires) {
+		return 0, nil, false
+	}
+	return r.status, r.body, true
+}
+
+func (ms *MemIdempotencyStore) Save(key string, status int, body []byte) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	now := time.Now()
+	for k, r := range ms.data {
+		if now.After(r.expires) {
+			delete(ms.data, k)
+		}
+	}
+	ms.data[key] = appliedRequest{
+		status:  status,
+		body:    body,
+		expires: now.Add(ms.ttl),
+	}
+	return nil
+}
+
+// PostgresIdempotencyStore keeps responses of applied requests in the database table for the specified time.
+type PostgresIdempotencyStore struct {
+	logger *slog.Logger
+	db     *sql.DB
+	ttl    time.Duration
+}
+
+func NewPostgresIdempotencyStore(db *sql.DB, ttl time.Duration, logger slog.Handler) (*PostgresIdempotencyStore, error) {
+	ps := &PostgresIdempotencyStore{
+		db:     db,
+		ttl:    ttl,
+		logger: slog.New(logger),
+	}
+	query := `
+		CREATE TABLE IF NOT EXISTS idempotency_key (
+			key varchar(128) PRIMARY KEY,
+			status integer NOT NULL,
+			body bytea,
+			created_at timestamptz NOT NULL DEFAULT now()
+		);
+	`
+	err := retry.Do(context.Background(), pgRetry, func(ctx context.Context) (err error) {
+		_, err = ps.db.ExecContext(ctx, query)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ps, nil
+}
+
+func (ps *PostgresIdempotencyStore) Get(key string) (int, []byte, bool) {
+	var (
+		status int
+		body   []byte
+	)
+	var row *sql.Row
+	err := retry.Do(context.Background(), pgRetry, func(ctx context.Context) (err error) {
+		row = ps.db.QueryRowContext(ctx,
+			`SELECT status, body FROM idempotency_key WHERE key = $1 AND created_at > $2;`,
+			key, time.Now().Add(-ps.ttl),
+		)
+		return row.Err()
+	})
+	if err != nil {
+		ps.logger.Error(err.Error())
+		return 0, nil, false
+	}
+	if err := row.Scan(&status, &body); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			ps.logger.Error(err.Error())
+		}
+		return 0, nil, false
+	}
+	return status, body, true
+}
+
+func (ps *PostgresIdempotencyStore) Save(key string, status int, body []byte) error {
+	query := `
+		INSERT INTO idempotency_key (key, status, body)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (key) DO UPDATE SET
+			status = excluded.status,
+			body = excluded.body,
+			created_at = now();`
+
+	err := retry.Do(context.Background(), pgRetry, func(ctx context.Context) (err error) {
+		if _, err = ps.db.ExecContext(ctx, `DELETE FROM idempotency_key WHERE created_at < $1;`, time.Now().Add(-ps.ttl)); err != nil {
+			return
+		}
+		_, err = ps.db.ExecContext(ctx, query, key, status, body)
+		return
+	})
+
+	if err != nil {
+		ps.logger.Error(err.Error())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package db
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemIdempotencyStore(t *testing.T) {
+	store := NewMemIdempotencyStore(50 * time.Millisecond)
+	_, _, ok := store.Get("key")
+	assert.False(t, ok)
+
+	require.NoError(t, store.Save("key", http.StatusOK, []byte(`{}`)))
+	status, body, ok := store.Get("key")
+	require.True(t, ok)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, []byte(`{}`), body)
+
+	time.Sleep(60 * time.Millisecond)
+	_, _, ok = store.Get("key")
+	assert.False(t, ok, "response expires after ttl")
+
+	require.NoError(t, store.Save("other", http.StatusOK, nil))
+	assert.Len(t, store.data, 1, "expired responses are removed on save")
+}
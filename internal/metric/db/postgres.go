@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log/slog"
@@ -9,9 +10,12 @@ import (
 	_ "github.com/jackc/pgx/v5/stdlib"
 
 	dto "github.com/Jeskay/musthave_metrics/internal/Dto"
-	"github.com/Jeskay/musthave_metrics/internal/util"
+	"github.com/Jeskay/musthave_metrics/pkg/retry"
 )
 
+// pgRetry retries database operations failed due to connection exceptions.
+var pgRetry = retry.Default.WithRetryable(retry.IsPGConnectionError)
+
 type PostgresStorage struct {
 	logger *slog.Logger
 	db     *sql.DB
@@ -37,10 +41,10 @@ func (ps *PostgresStorage) init() error {
 			gaugeValue  double precision
 		);
 	`
-	err := util.TryRun(func() (err error) {
-		_, err = ps.db.Exec(query)
+	err := retry.Do(context.Background(), pgRetry, func(ctx context.Context) (err error) {
+		_, err = ps.db.ExecContext(ctx, query)
 		return
-	}, util.IsPGConnectionError)
+	})
 
 	return err
 }
@@ -52,10 +56,10 @@ func (ps *PostgresStorage) Get(key string) (dto.Metrics, bool) {
 		counter sql.NullInt64
 	)
 	var row *sql.Row
-	err := util.TryRun(func() (err error) {
-		row = ps.db.QueryRow(`SELECT * FROM metric WHERE name = $1;`, key)
+	err := retry.Do(context.Background(), pgRetry, func(ctx context.Context) (err error) {
+		row = ps.db.QueryRowContext(ctx, `SELECT * FROM metric WHERE name = $1;`, key)
 		return row.Err()
-	}, util.IsPGConnectionError)
+	})
 
 	if err != nil {
 		slog.Error(err.Error())
@@ -80,10 +84,10 @@ func (ps *PostgresStorage) Set(value dto.Metrics) error {
 			gaugevalue = excluded.gaugevalue,
 			countervalue = metric.countervalue + excluded.countervalue;`
 
-	err := util.TryRun(func() (err error) {
-		_, err = ps.db.Exec(query, value.ID, counter, gauge)
+	err := retry.Do(context.Background(), pgRetry, func(ctx context.Context) (err error) {
+		_, err = ps.db.ExecContext(ctx, query, value.ID, counter, gauge)
 		return
-	}, util.IsPGConnectionError)
+	})
 
 	if err != nil {
 		ps.logger.Error(err.Error())
@@ -110,10 +114,10 @@ func (ps *PostgresStorage) SetMany(values []dto.Metrics) error {
 			countervalue = metric.countervalue + excluded.countervalue;
 	`)
 	qstr := query.String()
-	err := util.TryRun(func() (err error) {
-		_, err = ps.db.Exec(qstr, args...)
+	err := retry.Do(context.Background(), pgRetry, func(ctx context.Context) (err error) {
+		_, err = ps.db.ExecContext(ctx, qstr, args...)
 		return
-	}, util.IsPGConnectionError)
+	})
 
 	if err != nil {
 		ps.logger.Error(err.Error())
@@ -131,13 +135,13 @@ func (ps *PostgresStorage) GetMany(keys []string) ([]dto.Metrics, error) {
 	m := make([]dto.Metrics, 0)
 	qstr := "SELECT * FROM metric WHERE name = ANY($1)"
 	var rows *sql.Rows
-	err := util.TryRun(func() (err error) {
-		rows, err = ps.db.Query(qstr, keys)
+	err := retry.Do(context.Background(), pgRetry, func(ctx context.Context) (err error) {
+		rows, err = ps.db.QueryContext(ctx, qstr, keys)
 		if err == nil {
 			err = rows.Err()
 		}
 		return
-	}, util.IsPGConnectionError)
+	})
 
 	if err != nil {
 		ps.logger.Error(err.Error())
@@ -172,13 +176,13 @@ func (ps *PostgresStorage) GetAll() ([]dto.Metrics, error) {
 	)
 	m := make([]dto.Metrics, 0)
 	var rows *sql.Rows
-	err := util.TryRun(func() (err error) {
-		rows, err = ps.db.Query(`SELECT * FROM metric`)
+	err := retry.Do(context.Background(), pgRetry, func(ctx context.Context) (err error) {
+		rows, err = ps.db.QueryContext(ctx, `SELECT * FROM metric`)
 		if rows.Err() != nil {
 			return rows.Err()
 		}
 		return
-	}, util.IsPGConnectionError)
+	})
 
 	if err != nil {
 		return nil, err
@@ -205,9 +209,9 @@ func (ps *PostgresStorage) GetAll() ([]dto.Metrics, error) {
 }
 
 func (ps *PostgresStorage) Health() bool {
-	err := util.TryRun(func() (err error) {
-		err = ps.db.Ping()
+	err := retry.Do(context.Background(), pgRetry, func(ctx context.Context) (err error) {
+		err = ps.db.PingContext(ctx)
 		return
-	}, util.IsPGConnectionError)
+	})
 	return ps.db != nil && err == nil
 }
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/Jeskay/musthave_metrics/internal/metric"
+)
+
+// AgentConfig handles request of the agent settings.
+//
+//	Method: GET
+//	Endpoint: /agent/config?id={agent}&group={group}
+//
+// Example usage with curl:
+//
+//	curl -X GET http://localhost:9009/agent/config?id=host-1&group=web \
+//			-H 'If-None-Match: "5d41402abc4b2a76b9719d911017c592"'
+//
+//	On success, returns HTTP 200 OK with settings JSON and ETag header.
+//	If settings match If-None-Match header, returns HTTP 304 Not Modified.
+//	If settings are not configured, returns HTTP 404 Not Found.
+func AgentConfig(store *metric.AgentConfigStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if store == nil {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		settings, etag, err := store.Resolve(c.Query("id"), c.Query("group"))
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		c.Header("ETag", etag)
+		if c.GetHeader("If-None-Match") == etag {
+			c.AbortWithStatus(http.StatusNotModified)
+			return
+		}
+		c.JSON(http.StatusOK, settings)
+	}
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/Jeskay/musthave_metrics/internal/metric"
+)
+
+// Capabilities handles request of the protocols supported by the server.
+//
+//	Method: GET
+//	Endpoint: /capabilities
+//
+// Example usage with curl:
+//
+//	curl -X GET http://localhost:9009/capabilities
+//
+//	On success, returns HTTP 200 OK with JSON body:
+//	{
+//		"api_version": "1",
+//		"encodings": ["plain", "json"],
+//		"compression": ["gzip"],
+//		"signing": ["sha256"],
+//		"encryption": [],
+//		"batch": true,
+//		"max_batch_size": 1000
+//	}
+func Capabilities(svc *metric.MetricService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, svc.Capabilities())
+	}
+}
@@ -111,12 +111,17 @@ func UpdateMetricJson(svc *metric.MetricService) gin.HandlerFunc {
 //
 //	On success, returns HTTP 200 OK.
 //	On invalid JSON format returns HTTP 400 Bad request.
+//	On batch exceeding maximum size returns HTTP 413 Request Entity Too Large.
 func UpdateMetricsJson(svc *metric.MetricService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var metrics []dto.Metrics
 		if err := c.ShouldBindJSON(&metrics); err != nil {
 			c.AbortWithStatus(http.StatusBadRequest)
 		}
+		if limit := svc.Capabilities().MaxBatchSize; limit > 0 && len(metrics) > limit {
+			c.AbortWithStatus(http.StatusRequestEntityTooLarge)
+			return
+		}
 		metrics = dto.OptimizeMetrics(metrics)
 		if err := svc.SetMetrics(metrics); err != nil {
 			c.AbortWithStatus(http.StatusInternalServerError)
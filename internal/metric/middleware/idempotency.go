@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/Jeskay/musthave_metrics/internal"
+)
+
+type recordWriter struct {
+	gin.ResponseWriter
+	payload *bytes.Buffer
+}
+
+func (r *recordWriter) WriteString(s string) (int, error) {
+	r.payload.WriteString(s)
+	return r.ResponseWriter.WriteString(s)
+}
+
+func (r *recordWriter) Write(data []byte) (int, error) {
+	r.payload.Write(data)
+	return r.ResponseWriter.Write(data)
+}
+
+type keyLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+type keyLocks struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+func (l *keyLocks) lock(key string) {
+	l.mu.Lock()
+	kl, ok := l.locks[key]
+	if !ok {
+		kl = &keyLock{}
+		l.locks[key] = kl
+	}
+	kl.refs++
+	l.mu.Unlock()
+	kl.mu.Lock()
+}
+
+func (l *keyLocks) unlock(key string) {
+	l.mu.Lock()
+	kl := l.locks[key]
+	kl.refs--
+	if kl.refs == 0 {
+		delete(l.locks, key)
+	}
+	l.mu.Unlock()
+	kl.mu.Unlock()
+}
+
+// Idempotency returns handler function which applies requests with Idempotency-Key header only once.
+// Successful responses are saved to the store and repeated requests of the same client with the same key
+// receive the original response without being handled again. Keys of different clients do not clash.
+// Concurrent requests with the same key are processed one at a time.
+// Requests with keys longer than MaxIdempotencyKeyLength are rejected with HTTP 400 Bad Request.
+func Idempotency(store internal.IdempotencyStore, logger *slog.Logger) gin.HandlerFunc {
+	locks := &keyLocks{locks: make(map[string]*keyLock)}
+	return func(ctx *gin.Context) {
+		key := ctx.GetHeader(internal.IdempotencyHeader)
+		if key == "" || store == nil {
+			ctx.Next()
+			return
+		}
+		if len(key) > internal.MaxIdempotencyKeyLength {
+			ctx.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		key = scopedKey(ctx.Request, key)
+		locks.lock(key)
+		defer locks.unlock(key)
+
+		if status, body, ok := store.Get(key); ok {
+			ctx.Header("Idempotent-Replayed", "true")
+			ctx.Data(status, "application/json; charset=utf-8", body)
+			ctx.Abort()
+			return
+		}
+		w := &recordWriter{ctx.Writer, &bytes.Buffer{}}
+		ctx.Writer = w
+		ctx.Next()
+		if status := w.Status(); status >= http.StatusOK && status < http.StatusMultipleChoices {
+			if err := store.Save(key, status, w.payload.Bytes()); err != nil {
+				logger.Error("failed to save idempotent response", slog.String("error", err.Error()))
+			}
+		}
+	}
+}
+
+// scopedKey binds the idempotency key to the client which sent the request.
+// The result is hashed, so it fits the store regardless of the length of client identity.
+func scopedKey(req *http.Request, key string) string {
+	sum := sha256.Sum256([]byte(clientIdentity(req) + "\n" + key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Jeskay/musthave_metrics/internal"
+	"github.com/Jeskay/musthave_metrics/internal/metric/db"
+)
+
+// failingStore does not keep saved responses.
+type failingStore struct{}
+
+func (failingStore) Get(string) (int, []byte, bool) { return 0, nil, false }
+
+func (failingStore) Save(string, int, []byte) error {
+	return errors.New("value too long for type character varying(128)")
+}
+
+func idempotentRouter(store internal.IdempotencyStore, logger *slog.Logger, handled *atomic.Int64) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/updates", Idempotency(store, logger), func(ctx *gin.Context) {
+		n := handled.Add(1)
+		if ctx.Query("fail") != "" {
+			ctx.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		ctx.JSON(http.StatusOK, gin.H{"handled": n})
+	})
+	return r
+}
+
+func postIdempotent(r http.Handler, target, key string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, target, nil)
+	if key != "" {
+		req.Header.Set(internal.IdempotencyHeader, key)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestIdempotency(t *testing.T) {
+	var handled atomic.Int64
+	r := idempotentRouter(db.NewMemIdempotencyStore(time.Minute), slog.Default(), &handled)
+
+	first := postIdempotent(r, "/updates", "key")
+	assert.Equal(t, http.StatusOK, first.Code)
+	assert.JSONEq(t, `{"handled":1}`, first.Body.String())
+
+	replayed := postIdempotent(r, "/updates", "key")
+	assert.Equal(t, http.StatusOK, replayed.Code)
+	assert.Equal(t, first.Body.String(), replayed.Body.String(), "stored response is replayed")
+	assert.Equal(t, "true", replayed.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, int64(1), handled.Load())
+
+	assert.JSONEq(t, `{"handled":2}`, postIdempotent(r, "/updates", "other").Body.String())
+	assert.JSONEq(t, `{"handled":3}`, postIdempotent(r, "/updates", "").Body.String(), "request without key is always handled")
+	assert.JSONEq(t, `{"handled":4}`, postIdempotent(r, "/updates", "").Body.String())
+
+	assert.Equal(t, http.StatusBadRequest, postIdempotent(r, "/updates?fail=1", "failed").Code)
+	assert.Equal(t, http.StatusOK, postIdempotent(r, "/updates", "failed").Code, "unsuccessful response is not stored")
+	assert.Equal(t, int64(6), handled.Load())
+}
+
+func TestIdempotencyConcurrent(t *testing.T) {
+	var handled atomic.Int64
+	r := idempotentRouter(db.NewMemIdempotencyStore(time.Minute), slog.Default(), &handled)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Equal(t, http.StatusOK, postIdempotent(r, "/updates", "key").Code)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(1), handled.Load(), "concurrent requests with the same key are applied once")
+}
+
+func TestIdempotencyClientScope(t *testing.T) {
+	var handled atomic.Int64
+	r := idempotentRouter(db.NewMemIdempotencyStore(time.Minute), slog.Default(), &handled)
+	post := func(remote string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/updates", nil)
+		req.RemoteAddr = remote
+		req.Header.Set(internal.IdempotencyHeader, "key")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	assert.JSONEq(t, `{"handled":1}`, post("10.0.0.1:4000").Body.String())
+	assert.JSONEq(t, `{"handled":1}`, post("10.0.0.1:5000").Body.String(), "key is scoped by client address, not port")
+	assert.JSONEq(t, `{"handled":2}`, post("10.0.0.2:4000").Body.String(), "same key of another client is applied")
+	assert.Equal(t, int64(2), handled.Load())
+}
+
+func TestIdempotencyKeyTooLong(t *testing.T) {
+	var handled atomic.Int64
+	r := idempotentRouter(db.NewMemIdempotencyStore(time.Minute), slog.Default(), &handled)
+	key := strings.Repeat("k", internal.MaxIdempotencyKeyLength)
+	assert.Equal(t, http.StatusOK, postIdempotent(r, "/updates", key).Code)
+	assert.Equal(t, http.StatusBadRequest, postIdempotent(r, "/updates", key+"k").Code)
+	assert.Equal(t, int64(1), handled.Load())
+}
+
+func TestIdempotencySaveError(t *testing.T) {
+	var handled atomic.Int64
+	var logs bytes.Buffer
+	r := idempotentRouter(failingStore{}, slog.New(slog.NewTextHandler(&logs, nil)), &handled)
+	assert.Equal(t, http.StatusOK, postIdempotent(r, "/updates", "key").Code)
+	assert.Contains(t, logs.String(), "failed to save idempotent response")
+}
@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// bucketsCleanupSize is the amount of tracked clients after which idle buckets are evicted.
+const bucketsCleanupSize = 1024
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+// NewRateLimiter returns a new token bucket rate limiter which allows every client
+// to make rate requests per second with bursts of up to burst requests.
+// Clients are identified by TLS certificate common name or by IP address of the socket peer.
+func NewRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = int(math.Max(1, math.Ceil(rate)))
+	}
+	return &rateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Handle rejects requests of clients that exceeded their rate with HTTP 429 Too Many Requests.
+// Retry-After header contains amount of seconds until the next request is allowed.
+func (l *rateLimiter) Handle(ctx *gin.Context) {
+	ok, wait := l.allow(clientIdentity(ctx.Request))
+	if !ok {
+		ctx.Header("Retry-After", retryAfter(wait))
+		ctx.AbortWithStatus(http.StatusTooManyRequests)
+		return
+	}
+	ctx.Next()
+}
+
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if len(l.buckets) >= bucketsCleanupSize {
+		l.evict(now)
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// evict removes buckets which have been refilled completely since they are equal to new ones.
+func (l *rateLimiter) evict(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// InFlightLimiter returns handler function which limits amount of concurrently processed requests.
+// Requests above the limit are rejected with HTTP 429 Too Many Requests.
+func InFlightLimiter(limit int) gin.HandlerFunc {
+	sem := make(chan struct{}, limit)
+	return func(ctx *gin.Context) {
+		select {
+		case sem <- struct{}{}:
+			defer func() { <-sem }()
+			ctx.Next()
+		default:
+			ctx.Header("Retry-After", retryAfter(time.Second))
+			ctx.AbortWithStatus(http.StatusTooManyRequests)
+		}
+	}
+}
+
+// clientIdentity returns key of the client bucket. Client supplied headers are not used,
+// so the client can not get a new bucket by changing them.
+func clientIdentity(req *http.Request) string {
+	if req.TLS != nil && len(req.TLS.PeerCertificates) > 0 {
+		return "cn:" + req.TLS.PeerCertificates[0].Subject.CommonName
+	}
+	if ip := peerIP(req); ip != nil {
+		return "ip:" + ip.String()
+	}
+	return req.RemoteAddr
+}
+
+func retryAfter(wait time.Duration) string {
+	return strconv.Itoa(int(math.Max(1, math.Ceil(wait.Seconds()))))
+}
@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Jeskay/musthave_metrics/internal"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := NewRateLimiter(2, 3)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		ok, _ := l.allow("agent")
+		assert.True(t, ok)
+	}
+	ok, wait := l.allow("agent")
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	ok, _ = l.allow("other")
+	assert.True(t, ok)
+
+	now = now.Add(500 * time.Millisecond)
+	ok, _ = l.allow("agent")
+	assert.True(t, ok)
+	ok, _ = l.allow("agent")
+	assert.False(t, ok)
+
+	now = now.Add(time.Minute)
+	l.evict(now)
+	assert.Empty(t, l.buckets)
+}
+
+func TestRateLimiterHandle(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	now := time.Unix(0, 0)
+	l := NewRateLimiter(1, 2)
+	l.now = func() time.Time { return now }
+	r := gin.New()
+	r.GET("/", l.Handle, func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+	do := func(remote, realIP string, cert *x509.Certificate) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remote
+		req.Header.Set(internal.RealIPHeader, realIP)
+		if cert != nil {
+			req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, http.StatusOK, do("203.0.113.5:4000", fmt.Sprintf("10.0.0.%d", i), nil).Code)
+	}
+	w := do("203.0.113.5:5000", "10.0.0.9", nil)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code, "client is not identified by X-Real-IP header")
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusOK, do("203.0.113.6:4000", "", nil).Code)
+
+	agent := &x509.Certificate{Subject: pkix.Name{CommonName: "agent"}}
+	assert.Equal(t, http.StatusOK, do("203.0.113.5:4000", "", agent).Code, "certificate identifies the client")
+	assert.Equal(t, http.StatusOK, do("203.0.113.7:4000", "", agent).Code)
+	assert.Equal(t, http.StatusTooManyRequests, do("203.0.113.8:4000", "", agent).Code)
+
+	now = now.Add(time.Second)
+	assert.Equal(t, http.StatusOK, do("203.0.113.5:4000", "", nil).Code)
+}
+
+func TestInFlightLimiter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	r := gin.New()
+	r.GET("/", InFlightLimiter(1), func(ctx *gin.Context) {
+		if ctx.Query("block") != "" {
+			close(started)
+			<-release
+		}
+		ctx.Status(http.StatusOK)
+	})
+	do := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		return w
+	}
+
+	done := make(chan int)
+	go func() {
+		done <- do("/?block=1").Code
+	}()
+	<-started
+	w := do("/")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	close(release)
+	assert.Equal(t, http.StatusOK, <-done)
+	assert.Equal(t, http.StatusOK, do("/").Code, "slot is released when request completes")
+}
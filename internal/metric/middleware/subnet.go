@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/Jeskay/musthave_metrics/internal"
+)
+
+// TrustedSubnet returns handler function which rejects requests from clients outside
+// of the provided networks with HTTP 403 Forbidden. Client address is the socket peer address
+// unless the peer is one of the trusted proxies, then it is taken from X-Real-IP header.
+// Empty list of networks allows all requests.
+func TrustedSubnet(subnets, proxies []*net.IPNet) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if len(subnets) == 0 {
+			ctx.Next()
+			return
+		}
+		ip := clientIP(ctx.Request, proxies)
+		if ip == nil || !containsIP(subnets, ip) {
+			ctx.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// clientIP returns address of the client which sent the request. X-Real-IP header
+// is only trusted when it is set by one of the proxies.
+func clientIP(req *http.Request, proxies []*net.IPNet) net.IP {
+	ip := peerIP(req)
+	if ip == nil || !containsIP(proxies, ip) {
+		return ip
+	}
+	if header := req.Header.Get(internal.RealIPHeader); header != "" {
+		return net.ParseIP(header)
+	}
+	return ip
+}
+
+// peerIP returns address of the socket peer of the request.
+func peerIP(req *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+func containsIP(subnets []*net.IPNet, ip net.IP) bool {
+	for _, subnet := range subnets {
+		if subnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Jeskay/musthave_metrics/internal"
+)
+
+func mustParseCIDR(t *testing.T, cidr string) []*net.IPNet {
+	_, subnet, err := net.ParseCIDR(cidr)
+	require.NoError(t, err)
+	return []*net.IPNet{subnet}
+}
+
+func TestTrustedSubnet(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	subnets := mustParseCIDR(t, "10.0.0.0/8")
+	proxies := mustParseCIDR(t, "192.168.1.1/32")
+
+	tests := []struct {
+		name    string
+		subnets []*net.IPNet
+		remote  string
+		realIP  string
+		status  int
+	}{
+		{"no restriction", nil, "203.0.113.5:4000", "", http.StatusOK},
+		{"peer inside subnet", subnets, "10.1.2.3:4000", "", http.StatusOK},
+		{"peer outside subnet", subnets, "203.0.113.5:4000", "", http.StatusForbidden},
+		{"header from untrusted peer ignored", subnets, "203.0.113.5:4000", "10.1.2.3", http.StatusForbidden},
+		{"header from untrusted peer does not deny", subnets, "10.1.2.3:4000", "203.0.113.5", http.StatusOK},
+		{"header from trusted proxy", subnets, "192.168.1.1:4000", "10.1.2.3", http.StatusOK},
+		{"header from trusted proxy outside subnet", subnets, "192.168.1.1:4000", "203.0.113.5", http.StatusForbidden},
+		{"invalid header from trusted proxy", subnets, "192.168.1.1:4000", "invalid", http.StatusForbidden},
+		{"invalid peer address", subnets, "invalid", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.GET("/", TrustedSubnet(tt.subnets, proxies), func(ctx *gin.Context) {
+				ctx.Status(http.StatusOK)
+			})
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remote
+			if tt.realIP != "" {
+				req.Header.Set(internal.RealIPHeader, tt.realIP)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			assert.Equal(t, tt.status, w.Code)
+		})
+	}
+}
@@ -12,10 +12,40 @@ import (
 	"github.com/Jeskay/musthave_metrics/internal/metric/middleware"
 )
 
-func Init(config *config.ServerConfig, svc *metric.MetricService, template *template.Template) *gin.Engine {
+func Init(config *config.ServerConfig, svc *metric.MetricService, template *template.Template) (*gin.Engine, error) {
+	writeSubnets, err := config.Subnets(config.WriteSubnet)
+	if err != nil {
+		return nil, err
+	}
+	readSubnets, err := config.Subnets(config.ReadSubnet)
+	if err != nil {
+		return nil, err
+	}
+	adminSubnets, err := config.Subnets(config.AdminSubnet)
+	if err != nil {
+		return nil, err
+	}
+	proxies, err := config.Proxies()
+	if err != nil {
+		return nil, err
+	}
+
+	var agentConfig *metric.AgentConfigStore
+	if config.AgentConfigFile != "" {
+		if agentConfig, err = metric.NewAgentConfigStore(config.AgentConfigFile); err != nil {
+			return nil, err
+		}
+	}
+
 	r := gin.Default()
 	r.SetHTMLTemplate(template)
 	r.Use(middleware.Logger(svc.Logger))
+	if config.MaxInFlight > 0 {
+		r.Use(middleware.InFlightLimiter(config.MaxInFlight))
+	}
+	if config.ClientRateLimit > 0 {
+		r.Use(middleware.NewRateLimiter(config.ClientRateLimit, config.ClientBurst).Handle)
+	}
 	r.Use(middleware.HashDecoder(config.HashKey))
 	r.Use(middleware.HashEncoder(config.HashKey))
 	r.Use(middleware.GzipDecoder())
@@ -24,7 +54,11 @@ func Init(config *config.ServerConfig, svc *metric.MetricService, template *temp
 		r.Use(middleware.Decipher(privateKey))
 	}
 
-	v1 := r.Group("/update")
+	write := r.Group("", middleware.TrustedSubnet(writeSubnets, proxies), middleware.Idempotency(svc.IdempotencyStore(), svc.Logger))
+	read := r.Group("", middleware.TrustedSubnet(readSubnets, proxies))
+	admin := r.Group("", middleware.TrustedSubnet(adminSubnets, proxies))
+
+	v1 := write.Group("/update")
 	{
 		v1.POST("/", handlers.UpdateMetricJson(svc))
 		v1.POST("/counter/:name/:value", handlers.UpdateCounterMetricRaw(svc))
@@ -34,7 +68,7 @@ func Init(config *config.ServerConfig, svc *metric.MetricService, template *temp
 		})
 
 	}
-	v2 := r.Group("/value")
+	v2 := read.Group("/value")
 	{
 		v2.POST("/", handlers.GetMetricJson(svc))
 		v2.GET("/counter/:name", handlers.GetCounterMetric(svc))
@@ -44,8 +78,10 @@ func Init(config *config.ServerConfig, svc *metric.MetricService, template *temp
 		})
 
 	}
-	r.POST("/updates", handlers.UpdateMetricsJson(svc))
-	r.GET("/ping", handlers.Ping(svc))
-	r.GET("", handlers.ListMetrics(svc))
-	return r
+	write.POST("/updates", handlers.UpdateMetricsJson(svc))
+	admin.GET("/ping", handlers.Ping(svc))
+	r.GET("/agent/config", middleware.TrustedSubnet(writeSubnets, proxies), handlers.AgentConfig(agentConfig))
+	read.GET("/capabilities", handlers.Capabilities(svc))
+	read.GET("", handlers.ListMetrics(svc))
+	return r, nil
 }
@@ -16,6 +16,7 @@ import (
 // MetricService represents the service for storing and updating metric data.
 type MetricService struct {
 	storage     internal.Repositories
+	idempotency internal.IdempotencyStore
 	fileStorage *db.FileStorage
 	Logger      *slog.Logger // Instance of logger to write error and debug information to.
 	conf        config.ServerConfig
@@ -25,9 +26,10 @@ type MetricService struct {
 
 // NewMetricService function initialize and returns new MetricService instance.
 // The function also loads previously saved metric data from local storage if database is unaccessible.
-func NewMetricService(conf config.ServerConfig, logger slog.Handler, fileStorage *db.FileStorage, memoryStorage internal.Repositories) *MetricService {
+func NewMetricService(conf config.ServerConfig, logger slog.Handler, fileStorage *db.FileStorage, memoryStorage internal.Repositories, idempotency internal.IdempotencyStore) *MetricService {
 	service := &MetricService{
 		storage:     memoryStorage,
+		idempotency: idempotency,
 		fileStorage: fileStorage,
 		Logger:      slog.New(logger),
 		conf:        conf,
@@ -162,6 +164,31 @@ func (s *MetricService) GetAllMetrics() ([]dto.Metrics, error) {
 	return s.storage.GetAll()
 }
 
+// Capabilities returns protocols supported by the server.
+func (s *MetricService) Capabilities() dto.Capabilities {
+	caps := dto.Capabilities{
+		APIVersion:   dto.APIVersion,
+		Encodings:    []string{dto.EncodingPlain, dto.EncodingJSON},
+		Compression:  []string{dto.CompressionGzip},
+		Signing:      []string{},
+		Encryption:   []string{},
+		Batch:        true,
+		MaxBatchSize: s.conf.MaxBatchSize,
+	}
+	if s.conf.HashKey != "" {
+		caps.Signing = append(caps.Signing, dto.SigningSHA256)
+	}
+	if s.conf.TLSPrivate != "" {
+		caps.Encryption = append(caps.Encryption, dto.EncryptionRSAOAEP)
+	}
+	return caps
+}
+
+// IdempotencyStore function returns storage of the recently applied requests.
+func (s *MetricService) IdempotencyStore() internal.IdempotencyStore {
+	return s.idempotency
+}
+
 // DBHealth function returns a boolean value that indicates accessibility of the database.
 func (s *MetricService) DBHealth() bool {
 	return s.storage.Health()
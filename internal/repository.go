@@ -13,6 +13,12 @@ type Repositories interface {
 	GetAll() ([]dto.Metrics, error)
 }
 
+// IdempotencyStore keeps responses of recently applied requests by their idempotency keys.
+type IdempotencyStore interface {
+	Get(key string) (status int, body []byte, ok bool)
+	Save(key string, status int, body []byte) error
+}
+
 type MetricType string
 
 const (
@@ -21,3 +27,12 @@ const (
 )
 
 const HashHeader = "HashSHA256"
+
+// IdempotencyHeader contains unique key of the request, which must be applied only once.
+const IdempotencyHeader = "Idempotency-Key"
+
+// MaxIdempotencyKeyLength is the maximum length of the idempotency key accepted by the server.
+const MaxIdempotencyKeyLength = 128
+
+// RealIPHeader contains address of the agent's outbound interface.
+const RealIPHeader = "X-Real-IP"
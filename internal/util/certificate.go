@@ -0,0 +1,159 @@
+package util
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// CertificateReloader holds TLS key pair loaded from disk.
+// The pair is loaded again whenever either of the files is modified,
+// so certificates can be rotated without restarting the application.
+type CertificateReloader struct {
+	certPath string
+	keyPath  string
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	modTime  time.Time
+}
+
+// NewCertificateReloader loads key pair from the specified files.
+func NewCertificateReloader(certPath, keyPath string) (*CertificateReloader, error) {
+	r := &CertificateReloader{
+		certPath: certPath,
+		keyPath:  keyPath,
+	}
+	if _, err := r.Certificate(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Certificate returns current key pair reloading it if files have changed.
+// Previously loaded pair is returned when reload fails.
+func (r *CertificateReloader) Certificate() (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	modTime, err := latestModTime(r.certPath, r.keyPath)
+	if err != nil {
+		if r.cert != nil {
+			return r.cert, nil
+		}
+		return nil, err
+	}
+	if r.cert != nil && !modTime.After(r.modTime) {
+		return r.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(r.certPath, r.keyPath)
+	if err != nil {
+		if r.cert != nil {
+			return r.cert, nil
+		}
+		return nil, err
+	}
+	r.cert = &cert
+	r.modTime = modTime
+	return r.cert, nil
+}
+
+// GetCertificate can be used as tls.Config.GetCertificate callback.
+func (r *CertificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.Certificate()
+}
+
+// GetClientCertificate can be used as tls.Config.GetClientCertificate callback.
+func (r *CertificateReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return r.Certificate()
+}
+
+// CertPoolReloader holds pool of CA certificates loaded from PEM bundle
+// and reloads it whenever the bundle file is modified.
+type CertPoolReloader struct {
+	path    string
+	mu      sync.Mutex
+	pool    *x509.CertPool
+	modTime time.Time
+}
+
+// NewCertPoolReloader loads CA bundle from the specified file.
+func NewCertPoolReloader(path string) (*CertPoolReloader, error) {
+	r := &CertPoolReloader{path: path}
+	if _, err := r.Pool(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Pool returns current certificate pool reloading it if bundle has changed.
+// Previously loaded pool is returned when reload fails.
+func (r *CertPoolReloader) Pool() (*x509.CertPool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	modTime, err := latestModTime(r.path)
+	if err != nil {
+		if r.pool != nil {
+			return r.pool, nil
+		}
+		return nil, err
+	}
+	if r.pool != nil && !modTime.After(r.modTime) {
+		return r.pool, nil
+	}
+	b, err := os.ReadFile(r.path)
+	if err != nil {
+		if r.pool != nil {
+			return r.pool, nil
+		}
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		if r.pool != nil {
+			return r.pool, nil
+		}
+		return nil, errors.New("no certificates found in CA bundle")
+	}
+	r.pool = pool
+	r.modTime = modTime
+	return r.pool, nil
+}
+
+// VerifyConnection checks peer certificate chain against current pool.
+// It is used together with InsecureSkipVerify to verify server certificates
+// with CA bundle that might change during the application lifetime.
+func (r *CertPoolReloader) VerifyConnection(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("no peer certificates provided")
+	}
+	pool, err := r.Pool()
+	if err != nil {
+		return err
+	}
+	opts := x509.VerifyOptions{
+		Roots:         pool,
+		DNSName:       cs.ServerName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err = cs.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+func latestModTime(paths ...string) (time.Time, error) {
+	var latest time.Time
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return latest, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
@@ -0,0 +1,115 @@
+package util
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCertificate writes self-signed certificate with the common name and its key to the directory
+// and sets modification time of the files.
+func writeCertificate(t *testing.T, dir, name string, modTime time.Time) (certPath, keyPath string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certPath = filepath.Join(dir, "cert.pem")
+	keyPath = filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	require.NoError(t, os.Chtimes(certPath, modTime, modTime))
+	require.NoError(t, os.Chtimes(keyPath, modTime, modTime))
+	return certPath, keyPath
+}
+
+func commonName(t *testing.T, cert *tls.Certificate) string {
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	return leaf.Subject.CommonName
+}
+
+func TestCertificateReloader(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	certPath, keyPath := writeCertificate(t, dir, "first", now)
+
+	r, err := NewCertificateReloader(certPath, keyPath)
+	require.NoError(t, err)
+	cert, err := r.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, "first", commonName(t, cert))
+
+	writeCertificate(t, dir, "second", now.Add(time.Minute))
+	cert, err = r.GetClientCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, "second", commonName(t, cert), "modified pair is reloaded")
+
+	require.NoError(t, os.WriteFile(keyPath, []byte("broken"), 0o600))
+	require.NoError(t, os.Chtimes(keyPath, now.Add(2*time.Minute), now.Add(2*time.Minute)))
+	cert, err = r.Certificate()
+	require.NoError(t, err)
+	assert.Equal(t, "second", commonName(t, cert), "previous pair is kept when reload fails")
+
+	require.NoError(t, os.Remove(certPath))
+	cert, err = r.Certificate()
+	require.NoError(t, err)
+	assert.Equal(t, "second", commonName(t, cert), "previous pair is kept when files are missing")
+
+	_, err = NewCertificateReloader(certPath, keyPath)
+	assert.Error(t, err)
+}
+
+func TestCertPoolReloader(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	certPath, keyPath := writeCertificate(t, dir, "first", now)
+	first, err := tls.LoadX509KeyPair(certPath, keyPath)
+	require.NoError(t, err)
+	firstLeaf, err := x509.ParseCertificate(first.Certificate[0])
+	require.NoError(t, err)
+
+	r, err := NewCertPoolReloader(certPath)
+	require.NoError(t, err)
+	assert.NoError(t, r.VerifyConnection(tls.ConnectionState{ServerName: "first", PeerCertificates: []*x509.Certificate{firstLeaf}}))
+	assert.Error(t, r.VerifyConnection(tls.ConnectionState{ServerName: "other", PeerCertificates: []*x509.Certificate{firstLeaf}}))
+	assert.Error(t, r.VerifyConnection(tls.ConnectionState{ServerName: "first"}))
+
+	writeCertificate(t, dir, "second", now.Add(time.Minute))
+	assert.Error(t, r.VerifyConnection(tls.ConnectionState{ServerName: "first", PeerCertificates: []*x509.Certificate{firstLeaf}}),
+		"certificate is not trusted after the bundle is replaced")
+
+	require.NoError(t, os.WriteFile(certPath, []byte("no certificates"), 0o600))
+	require.NoError(t, os.Chtimes(certPath, now.Add(2*time.Minute), now.Add(2*time.Minute)))
+	pool, err := r.Pool()
+	require.NoError(t, err)
+	assert.NotNil(t, pool, "previous pool is kept when reload fails")
+
+	_, err = NewCertPoolReloader(certPath)
+	assert.Error(t, err)
+}
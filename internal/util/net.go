@@ -0,0 +1,24 @@
+package util
+
+import (
+	"net"
+	"net/url"
+	"strings"
+)
+
+// OutboundIP returns address of the local interface used to reach the specified host.
+// No packets are sent since UDP dial only resolves the route.
+func OutboundIP(address string) (net.IP, error) {
+	if u, err := url.Parse(address); err == nil && u.Host != "" {
+		address = u.Host
+	}
+	if !strings.Contains(address, ":") {
+		address += ":80"
+	}
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
@@ -0,0 +1,41 @@
+package retry
+
+import "sync"
+
+// Budget limits retries of the operations sharing it, so retries do not multiply
+// the load on the failing service. Every retry spends one token and every success
+// earns the ratio of a token. Budget is full when created.
+type Budget struct {
+	mu     sync.Mutex
+	tokens float64
+	max    float64
+	ratio  float64
+}
+
+// NewBudget returns budget of maximum tokens earning ratio of a token on every success.
+func NewBudget(tokens, ratio float64) *Budget {
+	return &Budget{tokens: tokens, max: tokens, ratio: ratio}
+}
+
+// Available returns number of the retries allowed now.
+func (b *Budget) Available() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return int(b.tokens)
+}
+
+func (b *Budget) deposit() {
+	b.mu.Lock()
+	b.tokens = min(b.max, b.tokens+b.ratio)
+	b.mu.Unlock()
+}
+
+func (b *Budget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
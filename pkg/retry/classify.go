@@ -0,0 +1,92 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// StatusError is unsuccessful HTTP response status.
+type StatusError struct {
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("server responded with status %d", e.Code)
+}
+
+// Any returns condition matching errors which match any of the conditions.
+func Any(conditions ...func(error) bool) func(error) bool {
+	return func(err error) bool {
+		for _, c := range conditions {
+			if c(err) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// IsPGConnectionError reports whether the error is PostgreSQL connection exception.
+func IsPGConnectionError(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgerrcode.IsConnectionException(pgErr.Code)
+}
+
+// IsConnectionRefused reports whether the connection was refused by the remote host.
+func IsConnectionRefused(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// IsNetworkError reports whether the error is caused by network failure such as
+// refused or reset connection, timeout or connection closed in the middle of response.
+// Errors of the HTTP client are classified by their cause, so certificate verification
+// and invalid request errors are not network failures.
+// Context cancellation is not treated as network failure.
+func IsNetworkError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && (opErr.Op == "dial" || opErr.Op == "read")
+}
+
+// IsRetryableStatus reports whether the request failed with the status may succeed later.
+func IsRetryableStatus(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// IsRetryableHTTP reports whether the request failed due to network failure
+// or with StatusError of retryable status.
+func IsRetryableHTTP(err error) bool {
+	var se *StatusError
+	if errors.As(err, &se) {
+		return IsRetryableStatus(se.Code)
+	}
+	return IsNetworkError(err)
+}
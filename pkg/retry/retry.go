@@ -0,0 +1,122 @@
+// Package retry runs operations repeatedly until they succeed, using exponential backoff between attempts.
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// ErrBudgetExhausted is returned when retry budget does not allow another attempt.
+var ErrBudgetExhausted = errors.New("retry budget exhausted")
+
+// Policy describes how the operation is retried.
+// Zero values of the intervals and multiplier are replaced with defaults,
+// zero MaxAttempts and MaxElapsedTime mean no limit.
+type Policy struct {
+	// InitialInterval is the delay before the first retry, 1 second by default.
+	InitialInterval time.Duration
+	// MaxInterval caps the delay between attempts, 1 minute by default.
+	MaxInterval time.Duration
+	// Multiplier increases the delay after every attempt, 2 by default.
+	Multiplier float64
+	// MaxAttempts limits number of attempts including the first one.
+	MaxAttempts int
+	// MaxElapsedTime limits time spent on the operation including delays.
+	MaxElapsedTime time.Duration
+	// Jitter randomizes every delay between zero and its computed value.
+	Jitter bool
+	// Retryable reports whether the error is worth retrying, all errors are retried when nil.
+	Retryable func(error) bool
+	// Budget shared between the calls limits retries when the most of the attempts fail.
+	Budget *Budget
+	// OnRetry is called before waiting for the next attempt.
+	OnRetry func(attempt int, err error, delay time.Duration)
+	// OnGiveUp is called when the operation failed after the last attempt.
+	OnGiveUp func(attempts int, err error)
+}
+
+// Default retries three times with delays of up to 1, 2 and 4 seconds.
+var Default = Policy{
+	InitialInterval: time.Second,
+	MaxInterval:     5 * time.Second,
+	MaxAttempts:     4,
+	Jitter:          true,
+}
+
+// WithRetryable returns copy of the policy retrying errors matching the condition.
+func (p Policy) WithRetryable(retryable func(error) bool) Policy {
+	p.Retryable = retryable
+	return p
+}
+
+// Do calls the function until it succeeds, returns not retryable error or the policy
+// gives up. The last error is returned in that case. Waiting is interrupted when
+// the context is done, context error is returned wrapping the last one.
+func Do(ctx context.Context, p Policy, f func(ctx context.Context) error) error {
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		err := f(ctx)
+		if err == nil {
+			if p.Budget != nil {
+				p.Budget.deposit()
+			}
+			return nil
+		}
+		if p.Retryable != nil && !p.Retryable(err) {
+			return err
+		}
+		delay := p.Backoff(attempt)
+		if (p.MaxAttempts > 0 && attempt >= p.MaxAttempts) ||
+			(p.MaxElapsedTime > 0 && time.Since(start)+delay > p.MaxElapsedTime) {
+			p.giveUp(attempt, err)
+			return err
+		}
+		if p.Budget != nil && !p.Budget.withdraw() {
+			err = fmt.Errorf("%w: %w", ErrBudgetExhausted, err)
+			p.giveUp(attempt, err)
+			return err
+		}
+		if p.OnRetry != nil {
+			p.OnRetry(attempt, err, delay)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w: %w", ctx.Err(), err)
+		case <-timer.C:
+		}
+	}
+}
+
+// Backoff returns delay after the specified attempt.
+func (p Policy) Backoff(attempt int) time.Duration {
+	initial, maxInterval, multiplier := p.InitialInterval, p.MaxInterval, p.Multiplier
+	if initial <= 0 {
+		initial = time.Second
+	}
+	if maxInterval <= 0 {
+		maxInterval = time.Minute
+	}
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	delay := time.Duration(math.Min(
+		float64(initial)*math.Pow(multiplier, float64(attempt-1)),
+		float64(maxInterval),
+	))
+	if p.Jitter && delay > 0 {
+		delay = time.Duration(rand.Int64N(int64(delay) + 1))
+	}
+	return delay
+}
+
+func (p Policy) giveUp(attempts int, err error) {
+	if p.OnGiveUp != nil {
+		p.OnGiveUp(attempts, err)
+	}
+}
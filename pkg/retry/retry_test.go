@@ -0,0 +1,163 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errTemporary = errors.New("temporary")
+
+func fastPolicy() Policy {
+	return Policy{InitialInterval: time.Millisecond, MaxInterval: 4 * time.Millisecond}
+}
+
+func TestDo(t *testing.T) {
+	p := fastPolicy()
+	p.MaxAttempts = 3
+	var retries []int
+	var gaveUp int
+	p.OnRetry = func(attempt int, err error, delay time.Duration) { retries = append(retries, attempt) }
+	p.OnGiveUp = func(attempts int, err error) { gaveUp = attempts }
+
+	calls := 0
+	err := Do(context.Background(), p, func(ctx context.Context) error {
+		calls++
+		if calls < 3 {
+			return errTemporary
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, retries)
+
+	calls = 0
+	err = Do(context.Background(), p, func(ctx context.Context) error {
+		calls++
+		return fmt.Errorf("attempt %d: %w", calls, errTemporary)
+	})
+	assert.EqualError(t, err, "attempt 3: temporary")
+	assert.Equal(t, 3, gaveUp)
+}
+
+func TestDoNotRetryable(t *testing.T) {
+	p := fastPolicy().WithRetryable(func(err error) bool { return errors.Is(err, errTemporary) })
+	calls := 0
+	errPermanent := errors.New("permanent")
+	err := Do(context.Background(), p, func(ctx context.Context) error {
+		calls++
+		return errPermanent
+	})
+	assert.ErrorIs(t, err, errPermanent)
+	assert.Equal(t, 1, calls)
+}
+
+func TestDoLimits(t *testing.T) {
+	p := Policy{InitialInterval: 20 * time.Millisecond, MaxElapsedTime: 50 * time.Millisecond}
+	calls := 0
+	err := Do(context.Background(), p, func(ctx context.Context) error {
+		calls++
+		return errTemporary
+	})
+	assert.ErrorIs(t, err, errTemporary)
+	assert.Equal(t, 2, calls, "the second retry would exceed elapsed time")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p = Policy{InitialInterval: time.Hour}
+	err = Do(ctx, p, func(ctx context.Context) error {
+		cancel()
+		return errTemporary
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorIs(t, err, errTemporary)
+}
+
+func TestBudget(t *testing.T) {
+	p := fastPolicy()
+	p.Budget = NewBudget(2, 0.5)
+	calls := 0
+	err := Do(context.Background(), p, func(ctx context.Context) error {
+		calls++
+		return errTemporary
+	})
+	assert.ErrorIs(t, err, ErrBudgetExhausted)
+	assert.Equal(t, 3, calls)
+	assert.Zero(t, p.Budget.Available())
+
+	for i := 0; i < 4; i++ {
+		assert.NoError(t, Do(context.Background(), p, func(ctx context.Context) error { return nil }))
+	}
+	assert.Equal(t, 2, p.Budget.Available())
+}
+
+func TestBackoff(t *testing.T) {
+	p := Policy{InitialInterval: time.Second, MaxInterval: 5 * time.Second}
+	assert.Equal(t, time.Second, p.Backoff(1))
+	assert.Equal(t, 2*time.Second, p.Backoff(2))
+	assert.Equal(t, 4*time.Second, p.Backoff(3))
+	assert.Equal(t, 5*time.Second, p.Backoff(4))
+
+	p.Jitter = true
+	for i := 0; i < 100; i++ {
+		d := p.Backoff(3)
+		assert.True(t, d >= 0 && d <= 4*time.Second, d)
+	}
+}
+
+func TestClassification(t *testing.T) {
+	pgErr := &pgconn.PgError{Code: pgerrcode.ConnectionFailure}
+	assert.True(t, IsPGConnectionError(fmt.Errorf("query: %w", pgErr)))
+	assert.False(t, IsPGConnectionError(&pgconn.PgError{Code: pgerrcode.UniqueViolation}))
+
+	refused := &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}
+	assert.True(t, IsConnectionRefused(refused))
+	assert.True(t, IsNetworkError(refused))
+	assert.False(t, IsNetworkError(context.Canceled))
+	assert.False(t, IsNetworkError(errTemporary))
+
+	assert.True(t, IsRetryableHTTP(&StatusError{Code: http.StatusServiceUnavailable}))
+	assert.False(t, IsRetryableHTTP(&StatusError{Code: http.StatusBadRequest}))
+	assert.True(t, IsRetryableHTTP(refused))
+
+	assert.True(t, Any(IsConnectionRefused, IsPGConnectionError)(pgErr))
+}
+
+func TestClassificationHTTPClient(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	_, err := http.Get(server.URL)
+	require.Error(t, err)
+	assert.False(t, IsNetworkError(err), "certificate verification error")
+	assert.False(t, IsRetryableHTTP(err))
+
+	_, err = http.Get("ftp://" + server.Listener.Addr().String())
+	require.Error(t, err)
+	assert.False(t, IsNetworkError(err), "unsupported scheme error")
+
+	addr := server.Listener.Addr().String()
+	server.Close()
+	_, err = http.Get("http://" + addr)
+	require.Error(t, err)
+	assert.True(t, IsNetworkError(err), "refused connection")
+
+	client := &http.Client{Timeout: time.Nanosecond}
+	_, err = client.Get("http://" + addr)
+	require.Error(t, err)
+	assert.True(t, IsNetworkError(err), "client timeout")
+
+	reset := &url.Error{Op: "Post", URL: "http://" + addr, Err: &net.OpError{Op: "read", Err: errors.New("connection closed")}}
+	assert.True(t, IsNetworkError(reset))
+}
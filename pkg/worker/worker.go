@@ -1,18 +1,33 @@
 package worker
 
-import "sync"
+import (
+	"sync"
+	"sync/atomic"
+)
 
 type WorkerPool[T any] struct {
-	count int
+	count atomic.Int64
 }
 
 func NewWorkerPool[T any](workerCount int) *WorkerPool[T] {
-	return &WorkerPool[T]{workerCount}
+	wp := &WorkerPool[T]{}
+	wp.count.Store(int64(workerCount))
+	return wp
+}
+
+// Resize changes number of workers started by the subsequent runs.
+func (wp *WorkerPool[T]) Resize(workerCount int) {
+	wp.count.Store(int64(workerCount))
+}
+
+// Size returns number of workers started by a run.
+func (wp *WorkerPool[T]) Size() int {
+	return int(wp.count.Load())
 }
 
 func (wp *WorkerPool[T]) Run(jobs <-chan T, handler func(T)) {
 	var wg sync.WaitGroup
-	for range wp.count {
+	for range wp.count.Load() {
 		wg.Add(1)
 		go func() {
 			for j := range jobs {